@@ -23,6 +23,7 @@
 package http
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -42,6 +43,53 @@ func ApiRequest(url string) (code int, body []byte, err error) {
 	return
 }
 
+// Write http api by HTTP POST with the json of req, and parse the code/data.
+// @remark the err is not nil when code is not 0, for example, the server deny the request.
+func ApiPost(url string, req interface{}) (code int, body []byte, err error) {
+	return ApiPostClient(http.DefaultClient, url, req)
+}
+
+// Write http api by HTTP POST with the client, for example, the client with timeout, see ApiPost.
+// @remark use the http.DefaultClient when client is nil.
+func ApiPostClient(client *http.Client, url string, req interface{}) (code int, body []byte, err error) {
+	if body, err = apiPost(client, url, req); err != nil {
+		return
+	}
+
+	if code, _, err = apiParse(url, body); err != nil {
+		return
+	}
+
+	return
+}
+
+// Write http api by HTTP POST.
+func apiPost(client *http.Client, url string, req interface{}) (body []byte, err error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	var b []byte
+	if b, err = json.Marshal(req); err != nil {
+		err = fmt.Errorf("api marshal failed, url=%v, req=%v, err is %v", url, req, err)
+		return
+	}
+
+	var resp *http.Response
+	if resp, err = client.Post(url, HttpJson, bytes.NewReader(b)); err != nil {
+		err = fmt.Errorf("api post failed, url=%v, err is %v", url, err)
+		return
+	}
+	defer resp.Body.Close()
+
+	if body, err = ioutil.ReadAll(resp.Body); err != nil {
+		err = fmt.Errorf("api read failed, url=%v, err is %v", url, err)
+		return
+	}
+
+	return
+}
+
 // Read http api by HTTP GET.
 func apiGet(url string) (body []byte, err error) {
 	var resp *http.Response
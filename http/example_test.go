@@ -124,3 +124,18 @@ func ExampleApiRequest() {
 	// user can use the body to parse to specified struct.
 	_ = body
 }
+
+func ExampleApiPost() {
+	var err error
+	var body []byte
+	req := map[string]interface{}{
+		"action": "on_publish",
+		"stream": "livestream",
+	}
+	if _, body, err = oh.ApiPost("http://127.0.0.1:8085/api/v1/streams", req); err != nil {
+		return
+	}
+
+	// user can use the body to parse to specified struct.
+	_ = body
+}
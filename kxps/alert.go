@@ -0,0 +1,179 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2013-2016 Oryx(ossrs)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// The alert for kxps, for example, the bitrate of stream collapse.
+package kxps
+
+import (
+	"fmt"
+	ol "github.com/ossrs/go-oryx-lib/logger"
+	"time"
+)
+
+// The condition to fire the alert.
+type AlertCondition int
+
+const (
+	// Fire when the rate is below the threshold, for example, the publisher stalled.
+	AlertBelow AlertCondition = iota
+	// Fire when the rate exceeds the threshold, for example, too many requests.
+	AlertAbove
+)
+
+func (v AlertCondition) String() string {
+	switch v {
+	case AlertBelow:
+		return "below"
+	case AlertAbove:
+		return "above"
+	default:
+		return fmt.Sprintf("AlertCondition(%d)", int(v))
+	}
+}
+
+// The alert on the trailing rate of window, checked when sampled.
+// For example, fire when the 10s kbps is below 100 for 3 samples,
+// and resolve when the 10s kbps is not below 200 for 3 samples.
+type Alert struct {
+	// The name of alert, for log.
+	Name string
+	// The window of rate, must be one of the sampled windows.
+	Window time.Duration
+	// The condition and threshold to fire, the rate is kbps for Kbps and rps for Krps.
+	Condition AlertCondition
+	Threshold float64
+	// The threshold to resolve, the hysteresis to avoid flapping,
+	// should not below the Threshold for AlertBelow or exceed it for AlertAbove.
+	// @remark use the Threshold when 0 and not HasResolve.
+	Resolve float64
+	// Whether use the Resolve even it's 0, for example, resolve AlertAbove when the rate is 0.
+	HasResolve bool
+	// The number of continuous samples to fire or resolve, use 1 when 0.
+	Samples int
+
+	// The callback when fired or resolved, with the rate of window.
+	// @remark the callback is called in the goroutine of registry, should not block.
+	OnFire    func(rate float64)
+	OnResolve func(rate float64)
+}
+
+// Get the threshold to resolve, the Threshold when Resolve is not set.
+func (v *Alert) resolve() float64 {
+	if v.Resolve == 0 && !v.HasResolve {
+		return v.Threshold
+	}
+	return v.Resolve
+}
+
+// The state of alert.
+type alertState struct {
+	alert *Alert
+	// the scale of rate, to kbps or rps.
+	scale float64
+	// whether the alert is fired.
+	firing bool
+	// the number of continuous samples to change the state.
+	matched int
+}
+
+// Check the alert with rate, @return true when the state changed.
+func (v *alertState) check(rate float64) bool {
+	a := v.alert
+
+	var match bool
+	if !v.firing {
+		match = (a.Condition == AlertBelow && rate < a.Threshold) || (a.Condition == AlertAbove && rate > a.Threshold)
+	} else {
+		resolve := a.resolve()
+		match = (a.Condition == AlertBelow && rate >= resolve) || (a.Condition == AlertAbove && rate <= resolve)
+	}
+
+	if !match {
+		v.matched = 0
+		return false
+	}
+
+	samples := a.Samples
+	if samples <= 0 {
+		samples = 1
+	}
+
+	if v.matched++; v.matched < samples {
+		return false
+	}
+
+	v.firing, v.matched = !v.firing, 0
+	return true
+}
+
+// Add the alert, the rate is multiplied by scale.
+func (v *kxps) addAlert(a *Alert, scale float64) error {
+	if a.Condition != AlertBelow && a.Condition != AlertAbove {
+		return fmt.Errorf("alert %v condition %v invalid", a.Name, a.Condition)
+	}
+	if !v.sampled(a.Window) {
+		return fmt.Errorf("alert %v window %v not sampled", a.Name, a.Window)
+	}
+	if resolve := a.resolve(); (a.Condition == AlertBelow && resolve < a.Threshold) || (a.Condition == AlertAbove && resolve > a.Threshold) {
+		return fmt.Errorf("alert %v resolve %v invalid for %v %v", a.Name, resolve, a.Condition, a.Threshold)
+	}
+
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	if v.closed {
+		return kxpsClosed
+	}
+
+	v.alerts = append(v.alerts, &alertState{alert: a, scale: scale})
+	return nil
+}
+
+// Check the alerts when sampled at now, with lock held,
+// @return the callbacks to call without lock.
+func (v *kxps) checkAlerts(now time.Time) (callbacks []func()) {
+	ctx := v.ctx
+
+	for _, s := range v.alerts {
+		a, rate := s.alert, v.doXps(now, v.last, s.alert.Window)*s.scale
+		if !s.check(rate) {
+			continue
+		}
+
+		if s.firing {
+			ol.W(ctx, "kxps alert", a.Name, "fire,", a.Window, "rate", rate, a.Condition, a.Threshold)
+			if a.OnFire != nil {
+				callbacks = append(callbacks, func() {
+					a.OnFire(rate)
+				})
+			}
+		} else {
+			ol.T(ctx, "kxps alert", a.Name, "resolve,", a.Window, "rate", rate)
+			if a.OnResolve != nil {
+				callbacks = append(callbacks, func() {
+					a.OnResolve(rate)
+				})
+			}
+		}
+	}
+
+	return
+}
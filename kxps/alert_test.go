@@ -0,0 +1,118 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2013-2016 Oryx(ossrs)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package kxps
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAlertState_Check(t *testing.T) {
+	s := &alertState{alert: &Alert{Condition: AlertBelow, Threshold: 100, Resolve: 200, Samples: 2}}
+
+	for i, c := range []struct {
+		rate   float64
+		change bool
+		firing bool
+	}{
+		{50, false, false},
+		{150, false, false}, // not continuous.
+		{50, false, false},
+		{50, true, true},
+		{150, false, true}, // hysteresis, not resolved.
+		{250, false, true},
+		{50, false, true}, // not continuous.
+		{250, false, true},
+		{250, true, false},
+	} {
+		if v := s.check(c.rate); v != c.change || s.firing != c.firing {
+			t.Errorf("#%v rate %v invalid change=%v, firing=%v", i, c.rate, v, s.firing)
+		}
+	}
+
+	s = &alertState{alert: &Alert{Condition: AlertAbove, Threshold: 100}}
+	if !s.check(101) || !s.firing {
+		t.Errorf("should fire")
+	} else if s.check(101) || !s.firing {
+		t.Errorf("should keep firing")
+	} else if !s.check(100) || s.firing {
+		t.Errorf("should resolve")
+	}
+
+	// Resolve only when the rate is 0.
+	s = &alertState{alert: &Alert{Condition: AlertAbove, Threshold: 100, HasResolve: true}}
+	if !s.check(101) || !s.firing {
+		t.Errorf("should fire")
+	} else if s.check(1) || !s.firing {
+		t.Errorf("should keep firing")
+	} else if !s.check(0) || s.firing {
+		t.Errorf("should resolve")
+	}
+}
+
+func TestKbps_AddAlert(t *testing.T) {
+	c := NewFakeClock(time.Unix(0, 0))
+	r := NewRegistryClock(nil, c, time.Second)
+
+	s := &mockKbpsSource{}
+	k := NewKbpsClock(nil, s, c, time.Second, time.Second)
+	defer k.Close()
+
+	if err := k.AddAlert(&Alert{Window: 10 * time.Second}); err == nil {
+		t.Errorf("should fail for window not sampled")
+	} else if err := k.AddAlert(&Alert{Window: time.Second, Threshold: 100, Resolve: 50}); err == nil {
+		t.Errorf("should fail for resolve")
+	} else if err := k.AddAlert(&Alert{Window: time.Second, Condition: AlertCondition(100)}); err == nil {
+		t.Errorf("should fail for condition")
+	}
+
+	var fired, resolved []float64
+	if err := k.AddAlert(&Alert{
+		Name: "stalled", Window: time.Second, Condition: AlertBelow, Threshold: 8, Samples: 2,
+		OnFire: func(rate float64) {
+			fired = append(fired, rate)
+			// the getters are ok in callback.
+			_ = k.Kbps(time.Second)
+		},
+		OnResolve: func(rate float64) {
+			resolved = append(resolved, rate)
+		},
+	}); err != nil {
+		t.Errorf("add alert failed, err is %v", err)
+	}
+
+	if err := r.Register("conn", nil, k); err != nil {
+		t.Errorf("register failed, err is %v", err)
+	}
+
+	// 1000B/s, that is 8kbps, then stalled.
+	for _, b := range []uint64{1000, 1000, 0, 0, 0, 1000, 1000} {
+		s.bytes += b
+		c.Advance(time.Second)
+	}
+
+	if len(fired) != 1 || fired[0] != 0 {
+		t.Errorf("invalid fired %v", fired)
+	} else if len(resolved) != 1 || resolved[0] != 8 {
+		t.Errorf("invalid resolved %v", resolved)
+	}
+}
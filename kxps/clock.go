@@ -0,0 +1,157 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2013-2016 Oryx(ossrs)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// The clock for kxps, the fake clock is used for test.
+package kxps
+
+import (
+	"sync"
+	"time"
+)
+
+// The clock to get the time and schedule the sample.
+type Clock interface {
+	// Get the current time.
+	Now() time.Time
+	// Call f every d with the time, until stop is called.
+	Every(d time.Duration, f func(now time.Time)) (stop func())
+}
+
+// The real clock, by time.Now and time.Ticker.
+var RealClock Clock = realClock{}
+
+type realClock struct{}
+
+func (v realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (v realClock) Every(d time.Duration, f func(now time.Time)) (stop func()) {
+	ticker := time.NewTicker(d)
+	done := make(chan bool)
+
+	go func() {
+		defer ticker.Stop()
+
+		for {
+			select {
+			case now := <-ticker.C:
+				f(now)
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+		})
+	}
+}
+
+// The fake clock, the time only changed by Advance,
+// and the scheduled functions are called in Advance.
+type FakeClock struct {
+	lock   *sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+// The scheduled function of fake clock.
+type fakeTimer struct {
+	next   time.Time
+	period time.Duration
+	f      func(now time.Time)
+}
+
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{
+		lock: &sync.Mutex{},
+		now:  now,
+	}
+}
+
+func (v *FakeClock) Now() time.Time {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	return v.now
+}
+
+func (v *FakeClock) Every(d time.Duration, f func(now time.Time)) (stop func()) {
+	if d <= 0 {
+		panic("non-positive interval for FakeClock.Every")
+	}
+
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	t := &fakeTimer{next: v.now.Add(d), period: d, f: f}
+	v.timers = append(v.timers, t)
+
+	return func() {
+		v.lock.Lock()
+		defer v.lock.Unlock()
+
+		for i, timer := range v.timers {
+			if timer == t {
+				v.timers = append(v.timers[:i], v.timers[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// Advance the time by d, call the scheduled functions in order of time,
+// for example, advance 10s to call the function scheduled every 1s for 10 times.
+func (v *FakeClock) Advance(d time.Duration) {
+	v.lock.Lock()
+	target := v.now.Add(d)
+	v.lock.Unlock()
+
+	for {
+		v.lock.Lock()
+
+		// the earliest timer before target.
+		var t *fakeTimer
+		for _, timer := range v.timers {
+			if !timer.next.After(target) && (t == nil || timer.next.Before(t.next)) {
+				t = timer
+			}
+		}
+
+		if t == nil {
+			v.now = target
+			v.lock.Unlock()
+			return
+		}
+
+		now := t.next
+		v.now = now
+		t.next = t.next.Add(t.period)
+		v.lock.Unlock()
+
+		// call without lock, the function may get time or stop the timer.
+		t.f(now)
+	}
+}
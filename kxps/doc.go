@@ -22,5 +22,10 @@
 // The oryx kxps package provides some kxps, for example:
 //	N kbps, N k bits per seconds
 //	N krps, N k requests per seconds
-// over some duration for instance 10s, 30s, 5m, average.
+// over some duration for instance 10s, 30s, 5m, average,
+// or user specified windows for instance 1s, 5s, 60s, 1h.
+// The xps is the trailing rate of window at any moment, or the EWMA rate.
+// The histogram provides the p50/p90/p99/max of values over the same windows,
+// for instance the latency of requests.
+// All objects are sampled by a Registry over a Clock, use the FakeClock to test without waiting.
 package kxps
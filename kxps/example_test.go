@@ -23,6 +23,7 @@ package kxps_test
 
 import (
 	"github.com/ossrs/go-oryx-lib/kxps"
+	"time"
 )
 
 func ExampleKrps() {
@@ -58,3 +59,101 @@ func ExampleKbps() {
 	_ = kbps.Kbps30s()
 	_ = kbps.Kbps300s()
 }
+
+func ExampleKbps_Windows() {
+	// user must provides the kbps source
+	var source kxps.KbpsSource
+
+	// sample every 1s, for 1s, 5s, 60s and 1h.
+	kbps := kxps.NewKbpsWindows(nil, source, time.Second, time.Second, 5*time.Second, time.Minute, time.Hour)
+	defer kbps.Close()
+
+	if err := kbps.Start(); err != nil {
+		return
+	}
+
+	_ = kbps.Kbps(time.Second)
+	_ = kbps.Kbps(time.Hour)
+}
+
+func ExampleKrps_RpsEwma() {
+	// user must provides the krps source
+	var source kxps.KrpsSource
+
+	// the 1/5/15 minutes load average of rps.
+	krps := kxps.NewKrpsWindows(nil, source, 5*time.Second, time.Minute, 5*time.Minute, 15*time.Minute)
+	defer krps.Close()
+
+	if err := krps.Start(); err != nil {
+		return
+	}
+
+	_ = krps.RpsEwma(time.Minute)
+	_ = krps.RpsEwma(5 * time.Minute)
+	_ = krps.RpsEwma(15 * time.Minute)
+}
+
+func ExampleHistogram() {
+	// the latency of api, sample every 1s, for 10s and 60s.
+	latency := kxps.NewHistogramWindows(nil, time.Second, 10*time.Second, time.Minute)
+	defer latency.Close()
+
+	if err := latency.Start(); err != nil {
+		return
+	}
+
+	// user should observe the latency of api, or use the NewHistogramHandler.
+	start := time.Now()
+	latency.ObserveSince(start)
+
+	_ = latency.P50(10 * time.Second)
+	_ = latency.P99(10 * time.Second)
+	_ = latency.Max(time.Minute)
+}
+
+func ExampleFakeClock() {
+	// user must provides the kbps source
+	var source kxps.KbpsSource
+
+	// the objects are sampled by registry when advance the fake clock.
+	clock := kxps.NewFakeClock(time.Now())
+	registry := kxps.NewRegistryClock(nil, clock, time.Second)
+
+	kbps := kxps.NewKbpsClock(nil, source, clock, time.Second, 10*time.Second)
+	defer kbps.Close()
+
+	if err := registry.Register("conn", nil, kbps); err != nil {
+		return
+	}
+
+	// sampled 10 times immediately, without waiting.
+	clock.Advance(10 * time.Second)
+
+	_ = kbps.Kbps(10 * time.Second)
+}
+
+func ExampleKbps_AddAlert() {
+	// user must provides the kbps source
+	var source kxps.KbpsSource
+
+	kbps := kxps.NewKbps(nil, source)
+	defer kbps.Close()
+
+	// fire when the 10s kbps below 100 for 3 samples, resolve when not below 200.
+	if err := kbps.AddAlert(&kxps.Alert{
+		Name: "publisher-stalled", Window: 10 * time.Second,
+		Condition: kxps.AlertBelow, Threshold: 100, Resolve: 200, Samples: 3,
+		OnFire: func(rate float64) {
+			// the stream is stalled.
+		},
+		OnResolve: func(rate float64) {
+			// the stream is recovered.
+		},
+	}); err != nil {
+		return
+	}
+
+	if err := kbps.Start(); err != nil {
+		return
+	}
+}
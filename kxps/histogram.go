@@ -0,0 +1,413 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2013-2016 Oryx(ossrs)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// The histogram is about the distribution, for example, the latency or size.
+package kxps
+
+import (
+	"fmt"
+	ol "github.com/ossrs/go-oryx-lib/logger"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// The relative accuracy of quantile, the error of p99 is less than 1%.
+const sketchAccuracy = 0.01
+
+// The value not larger than this is counted as zero.
+const sketchMinValue = 1e-9
+
+var (
+	sketchGamma    = (1 + sketchAccuracy) / (1 - sketchAccuracy)
+	sketchLogGamma = math.Log(sketchGamma)
+)
+
+// The mergeable sketch in DDSketch style, the values are counted by logarithmic buckets,
+// so the quantile is in relative accuracy, and two sketches can be merged by adding buckets.
+// @see https://arxiv.org/abs/1908.10693
+type sketch struct {
+	// the count of bucket, value in (gamma^(i-1), gamma^i] is counted in bucket i.
+	buckets map[int]uint64
+	// the count of values not larger than sketchMinValue.
+	zeros uint64
+	count uint64
+	sum   float64
+	max   float64
+}
+
+func newSketch() *sketch {
+	return &sketch{buckets: make(map[int]uint64)}
+}
+
+func (v *sketch) add(x float64) {
+	if v.count == 0 || x > v.max {
+		v.max = x
+	}
+	v.count++
+	v.sum += x
+
+	if x <= sketchMinValue {
+		v.zeros++
+		return
+	}
+	v.buckets[int(math.Ceil(math.Log(x)/sketchLogGamma))]++
+}
+
+func (v *sketch) merge(o *sketch) {
+	if o.count == 0 {
+		return
+	}
+
+	if v.count == 0 || o.max > v.max {
+		v.max = o.max
+	}
+	v.count += o.count
+	v.sum += o.sum
+	v.zeros += o.zeros
+
+	for i, c := range o.buckets {
+		v.buckets[i] += c
+	}
+}
+
+// Get the q-quantile, for example, 0.99 for p99, 0 when empty.
+func (v *sketch) quantile(q float64) float64 {
+	if v.count == 0 {
+		return 0
+	}
+	if q >= 1 {
+		return v.max
+	}
+
+	rank := uint64(math.Max(q, 0) * float64(v.count-1))
+	if rank < v.zeros {
+		return 0
+	}
+
+	keys := make([]int, 0, len(v.buckets))
+	for i := range v.buckets {
+		keys = append(keys, i)
+	}
+	sort.Ints(keys)
+
+	n := v.zeros
+	for _, i := range keys {
+		if n += v.buckets[i]; n > rank {
+			// the middle of bucket, in relative accuracy.
+			return math.Min(2*math.Pow(sketchGamma, float64(i))/(sketchGamma+1), v.max)
+		}
+	}
+	return v.max
+}
+
+// The sketch of a tick, ends at the sample time.
+type slot struct {
+	at     time.Time
+	sketch *sketch
+}
+
+// The object to calc the percentile of values, for example, the latency of requests.
+type Histogram interface {
+	// Start to sample by the DefaultRegistry, which own one goroutine for all objects.
+	Start() (err error)
+
+	// Observe a value, for example, the size of message.
+	Observe(value float64)
+	// Observe the duration since start in ms, for example, the latency of request.
+	ObserveSince(start time.Time)
+
+	// Get the q-quantile in last window, for example, 0.99 for p99,
+	// 0 if the window is not sampled or no value.
+	Quantile(window time.Duration, q float64) float64
+	// Get the p50 in last window.
+	P50(window time.Duration) float64
+	// Get the p90 in last window.
+	P90(window time.Duration) float64
+	// Get the p99 in last window.
+	P99(window time.Duration) float64
+	// Get the max value in last window.
+	Max(window time.Duration) float64
+
+	// Get the total number and sum of observed values.
+	Count() uint64
+	Sum() float64
+	// Get the observed values per seconds in last window.
+	Rps(window time.Duration) float64
+
+	// Merge the observed values of o to this histogram, for example, to aggregate
+	// the histograms of connections, the tick and windows of o should be the same.
+	Merge(o Histogram) error
+
+	// The sampler to register to Registry, see Registry.Register.
+	Sampler
+
+	// When closed, this histogram is removed from registry and should never use again.
+	io.Closer
+}
+
+// The implementation object.
+type histogram struct {
+	imp  *kxps
+	lock *sync.Mutex
+	// the total number and sum of observed values.
+	count uint64
+	sum   float64
+	// the sketch of current tick.
+	current *sketch
+	// the sketches of ticks, enough for the max window.
+	slots []slot
+	// the index of oldest slot.
+	head int
+	size int
+}
+
+// Create the histogram for 10s, 30s and 300s, sample every 10s.
+func NewHistogram(ctx ol.Context) Histogram {
+	return NewHistogramWindows(ctx, defaultTick, defaultWindows...)
+}
+
+// Create the histogram for windows, sample every tick,
+// for example, tick 1s for windows 1s, 5s, 60s and 1h.
+func NewHistogramWindows(ctx ol.Context, tick time.Duration, windows ...time.Duration) Histogram {
+	return NewHistogramClock(ctx, RealClock, tick, windows...)
+}
+
+// Create the histogram with clock, for example, the FakeClock for test,
+// @remark the Registry to sample it should use the same clock, see NewRegistryClock.
+func NewHistogramClock(ctx ol.Context, clock Clock, tick time.Duration, windows ...time.Duration) Histogram {
+	v := &histogram{
+		lock:    &sync.Mutex{},
+		current: newSketch(),
+	}
+	v.imp = newKxpsWindows(ctx, v, clock, tick, windows)
+	v.imp.onSample = v.rotate
+
+	// one slot for each sampled point.
+	v.slots = make([]slot, len(v.imp.points.points))
+
+	return v
+}
+
+func (v *histogram) Count() uint64 {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	return v.count
+}
+
+func (v *histogram) Sum() float64 {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	return v.sum
+}
+
+func (v *histogram) Observe(value float64) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	v.count++
+	v.sum += value
+	v.current.add(value)
+}
+
+func (v *histogram) ObserveSince(start time.Time) {
+	v.Observe(float64(v.imp.clock.Now().Sub(start)) / float64(time.Millisecond))
+}
+
+// Archive the current sketch as the slot of tick, when sampled.
+func (v *histogram) rotate(now time.Time) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	s := slot{at: now, sketch: v.current}
+	v.current = newSketch()
+
+	if v.size < len(v.slots) {
+		v.slots[(v.head+v.size)%len(v.slots)] = s
+		v.size++
+		return
+	}
+
+	v.slots[v.head] = s
+	v.head = (v.head + 1) % len(v.slots)
+}
+
+// Merge the sketches in window at now.
+func (v *histogram) window(now time.Time, window time.Duration) *sketch {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	merged := newSketch()
+	merged.merge(v.current)
+
+	from := now.Add(-window)
+	for i := v.size - 1; i >= 0; i-- {
+		s := v.slots[(v.head+i)%len(v.slots)]
+		if !s.at.After(from) {
+			break
+		}
+		merged.merge(s.sketch)
+	}
+
+	return merged
+}
+
+func (v *histogram) Merge(o Histogram) error {
+	h, ok := o.(*histogram)
+	if !ok {
+		return fmt.Errorf("histogram %T is not mergeable", o)
+	}
+	if h == v {
+		return fmt.Errorf("histogram should not merge itself")
+	}
+
+	if h.imp.tick != v.imp.tick {
+		return fmt.Errorf("tick %v of histogram should be %v", h.imp.tick, v.imp.tick)
+	}
+	if len(h.imp.windows) != len(v.imp.windows) {
+		return fmt.Errorf("windows %v of histogram should be %v", h.imp.windows, v.imp.windows)
+	}
+	for _, window := range h.imp.windows {
+		if !v.imp.sampled(window) {
+			return fmt.Errorf("windows %v of histogram should be %v", h.imp.windows, v.imp.windows)
+		}
+	}
+
+	// copy the sketches of o, to avoid lock both histograms.
+	h.lock.Lock()
+	count, sum := h.count, h.sum
+	current := newSketch()
+	current.merge(h.current)
+	slots := make([]slot, 0, h.size)
+	for i := 0; i < h.size; i++ {
+		s := slot{at: h.slots[(h.head+i)%len(h.slots)].at, sketch: newSketch()}
+		s.sketch.merge(h.slots[(h.head+i)%len(h.slots)].sketch)
+		slots = append(slots, s)
+	}
+	h.lock.Unlock()
+
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	v.count += count
+	v.sum += sum
+	v.current.merge(current)
+
+	// merge each slot of o to the slot which covers its time, the slot covers the time from previous slot,
+	// @remark the slot which is older than all slots is out of windows, only counted in total.
+	for _, s := range slots {
+		if to := v.covered(s.at); to != nil {
+			to.merge(s.sketch)
+		}
+	}
+
+	return nil
+}
+
+// Get the sketch which covers the time, with lock held, nil if older than all slots.
+func (v *histogram) covered(at time.Time) *sketch {
+	if v.size == 0 {
+		return v.current
+	}
+
+	from := v.slots[v.head].at.Add(-v.imp.tick)
+	for i := 0; i < v.size; i++ {
+		s := v.slots[(v.head+i)%len(v.slots)]
+		if at.After(from) && !at.After(s.at) {
+			return s.sketch
+		}
+		from = s.at
+	}
+
+	if at.After(from) {
+		return v.current
+	}
+	return nil
+}
+
+func (v *histogram) Quantile(window time.Duration, q float64) float64 {
+	if !v.imp.isStarted() {
+		panic("should start histogram first.")
+	}
+	return v.quantile(v.imp.clock.Now(), window, q)
+}
+
+func (v *histogram) quantile(now time.Time, window time.Duration, q float64) float64 {
+	if !v.imp.sampled(window) {
+		return 0
+	}
+	return v.window(now, window).quantile(q)
+}
+
+func (v *histogram) P50(window time.Duration) float64 {
+	return v.Quantile(window, 0.5)
+}
+
+func (v *histogram) P90(window time.Duration) float64 {
+	return v.Quantile(window, 0.9)
+}
+
+func (v *histogram) P99(window time.Duration) float64 {
+	return v.Quantile(window, 0.99)
+}
+
+func (v *histogram) Max(window time.Duration) float64 {
+	return v.Quantile(window, 1)
+}
+
+func (v *histogram) Rps(window time.Duration) float64 {
+	if !v.imp.isStarted() {
+		panic("should start histogram first.")
+	}
+	return v.imp.Xps(window)
+}
+
+func (v *histogram) Windows() []time.Duration {
+	return v.imp.Windows()
+}
+
+func (v *histogram) sampler() *kxps {
+	return v.imp
+}
+
+func (v *histogram) Close() (err error) {
+	return v.imp.Close()
+}
+
+func (v *histogram) Start() (err error) {
+	return v.imp.Start(v)
+}
+
+// The http handler which observe the latency in ms of each request to h,
+// for the api built with the http package, for example:
+//
+//	http.Handle("/api/v1/version", kxps.NewHistogramHandler(latency, oh.Data(ctx, version)))
+func NewHistogramHandler(latency Histogram, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer latency.ObserveSince(latency.sampler().clock.Now())
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,253 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2013-2016 Oryx(ossrs)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package kxps
+
+import (
+	"bytes"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSketch_Quantile(t *testing.T) {
+	s := newSketch()
+	if v := s.quantile(0.5); v != 0 {
+		t.Errorf("invalid quantile %v", v)
+	}
+
+	for i := 1; i <= 1000; i++ {
+		s.add(float64(i))
+	}
+
+	for q, expect := range map[float64]float64{0.5: 500, 0.9: 900, 0.99: 990} {
+		if v := s.quantile(q); math.Abs(v-expect) > expect*sketchAccuracy*2 {
+			t.Errorf("invalid q%v=%v, expect %v", q, v, expect)
+		}
+	}
+
+	if v := s.quantile(1); v != 1000 {
+		t.Errorf("invalid max %v", v)
+	} else if s.count != 1000 || s.sum != 500500 {
+		t.Errorf("invalid count=%v, sum=%v", s.count, s.sum)
+	}
+
+	// the zeros.
+	s = newSketch()
+	s.add(0)
+	s.add(0)
+	s.add(10)
+	if v := s.quantile(0.5); v != 0 {
+		t.Errorf("invalid quantile %v", v)
+	} else if v := s.quantile(1); v != 10 {
+		t.Errorf("invalid quantile %v", v)
+	}
+}
+
+func TestSketch_Merge(t *testing.T) {
+	s0, s1 := newSketch(), newSketch()
+	for i := 1; i <= 100; i++ {
+		s0.add(float64(i))
+		s1.add(float64(i + 100))
+	}
+
+	s0.merge(s1)
+	s0.merge(newSketch())
+	if s0.count != 200 {
+		t.Errorf("invalid count %v", s0.count)
+	} else if v := s0.quantile(1); v != 200 {
+		t.Errorf("invalid max %v", v)
+	} else if v := s0.quantile(0.5); math.Abs(v-100) > 100*sketchAccuracy*2 {
+		t.Errorf("invalid p50 %v", v)
+	}
+}
+
+func TestHistogram_Windows(t *testing.T) {
+	h := NewHistogramWindows(nil, time.Second, time.Second, 3*time.Second).(*histogram)
+
+	// 10ms in first second, then 100ms for each second.
+	for i := 0; i < 4; i++ {
+		for j := 0; j < 10; j++ {
+			if i == 0 {
+				h.Observe(10)
+			} else {
+				h.Observe(100)
+			}
+		}
+		if err := h.imp.doSample(time.Unix(int64(i+1), 0)); err != nil {
+			t.Errorf("sample failed, err is %v", err)
+		}
+	}
+
+	now := time.Unix(4, 0)
+	if v := h.quantile(now, time.Second, 0.5); math.Abs(v-100) > 1 {
+		t.Errorf("invalid p50 %v", v)
+	} else if v := h.quantile(now, 3*time.Second, 0.01); math.Abs(v-100) > 1 {
+		t.Errorf("invalid p1 %v", v)
+	} else if v := h.quantile(now.Add(-time.Second), 3*time.Second, 0.01); math.Abs(v-10) > 1 {
+		t.Errorf("invalid p1 %v", v)
+	} else if v := h.quantile(now, 5*time.Second, 0.5); v != 0 {
+		t.Errorf("invalid p50 %v for not sampled", v)
+	} else if h.Count() != 40 || h.Sum() != 3100 {
+		t.Errorf("invalid count=%v, sum=%v", h.Count(), h.Sum())
+	} else if v := h.imp.xps(now, h.Count(), 3*time.Second); v != 10 {
+		t.Errorf("invalid rps %v", v)
+	}
+}
+
+func TestHistogram_Merge(t *testing.T) {
+	a := NewHistogramWindows(nil, time.Second, time.Second, 3*time.Second).(*histogram)
+	b := NewHistogramWindows(nil, time.Second, time.Second, 3*time.Second).(*histogram)
+
+	// The a got 100ms for each second, the b got 10ms in last second.
+	for i := 0; i < 4; i++ {
+		for j := 0; j < 10; j++ {
+			a.Observe(100)
+			if i == 3 {
+				b.Observe(10)
+			}
+		}
+		for _, h := range []*histogram{a, b} {
+			if err := h.imp.doSample(time.Unix(int64(i+1), 0)); err != nil {
+				t.Errorf("sample failed, err is %v", err)
+			}
+		}
+	}
+	b.Observe(1)
+
+	if err := a.Merge(b); err != nil {
+		t.Errorf("merge failed, err is %v", err)
+	}
+
+	now := time.Unix(4, 0)
+	if a.Count() != 51 || a.Sum() != 4101 {
+		t.Errorf("invalid count=%v, sum=%v", a.Count(), a.Sum())
+	} else if v := a.quantile(now, time.Second, 0.1); math.Abs(v-10) > 1 {
+		t.Errorf("invalid p10 %v", v)
+	} else if v := a.covered(time.Unix(3, 0)).count; v != 10 {
+		t.Errorf("invalid count %v of slot 3s", v)
+	} else if v := a.covered(time.Unix(4, 0)).count; v != 20 {
+		t.Errorf("invalid count %v of slot 4s", v)
+	} else if v := a.quantile(now.Add(time.Second), time.Second, 0); math.Abs(v-1) > 0.1 {
+		t.Errorf("invalid p0 %v", v)
+	} else if b.Count() != 11 {
+		t.Errorf("invalid count=%v of merged", b.Count())
+	}
+
+	// The tick and windows should be the same.
+	if err := a.Merge(a); err == nil {
+		t.Errorf("should fail for itself")
+	} else if err := a.Merge(NewHistogramWindows(nil, time.Second, time.Second)); err == nil {
+		t.Errorf("should fail for windows")
+	} else if err := a.Merge(NewHistogramWindows(nil, 2*time.Second, time.Second, 3*time.Second)); err == nil {
+		t.Errorf("should fail for tick")
+	}
+}
+
+func TestHistogram_Metrics(t *testing.T) {
+	r := NewRegistry(nil, time.Second)
+
+	h := NewHistogramWindows(nil, time.Second, time.Second)
+	defer h.Close()
+
+	if err := r.Register("api_latency", Labels{"api": "version"}, h); err != nil {
+		t.Errorf("register failed, err is %v", err)
+	}
+
+	handler := NewHistogramHandler(h, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/v1/version", nil))
+
+	var b bytes.Buffer
+	if err := r.WriteMetrics(&b, false); err != nil {
+		t.Errorf("write failed, err is %v", err)
+	}
+
+	out := b.String()
+	for _, line := range []string{
+		"# TYPE api_latency summary\n",
+		`api_latency{api="version",quantile="0.99",window="1s"} `,
+		`api_latency_max{api="version",window="1s"} `,
+		`api_latency_count{api="version"} 1` + "\n",
+		`api_latency_sum{api="version"} `,
+	} {
+		if !strings.Contains(out, line) {
+			t.Errorf("no %v in %v", line, out)
+		}
+	}
+	if strings.Contains(out, "api_latency_count_total") || strings.Contains(out, "api_latency_sum_total") {
+		t.Errorf("summary should not has _total, %v", out)
+	}
+}
+
+func TestHistogram_Summary(t *testing.T) {
+	c := NewFakeClock(time.Unix(100, 0))
+	r := NewRegistryClock(nil, c, time.Second)
+
+	h := NewHistogramClock(nil, c, time.Second, time.Second, 2*time.Second).(*histogram)
+	defer h.Close()
+
+	if err := r.Register("api_latency", Labels{"api": "version"}, h); err != nil {
+		t.Errorf("register failed, err is %v", err)
+	}
+
+	h.Observe(10)
+	h.Observe(20)
+	h.Observe(30)
+	c.Advance(time.Second)
+
+	now := c.Now()
+	q := func(window time.Duration, q float64) string {
+		return formatValue(h.quantile(now, window, q))
+	}
+
+	for _, openMetrics := range []bool{false, true} {
+		var b bytes.Buffer
+		if err := r.WriteMetrics(&b, openMetrics); err != nil {
+			t.Errorf("write failed, err is %v", err)
+		}
+
+		expect := strings.Join([]string{
+			"# HELP api_latency The quantiles of observed values in trailing window, with total sum and count.",
+			"# TYPE api_latency summary",
+			`api_latency{api="version",quantile="0.5",window="1s"} ` + q(time.Second, 0.5),
+			`api_latency{api="version",quantile="0.9",window="1s"} ` + q(time.Second, 0.9),
+			`api_latency{api="version",quantile="0.99",window="1s"} ` + q(time.Second, 0.99),
+			`api_latency{api="version",quantile="0.5",window="2s"} ` + q(2*time.Second, 0.5),
+			`api_latency{api="version",quantile="0.9",window="2s"} ` + q(2*time.Second, 0.9),
+			`api_latency{api="version",quantile="0.99",window="2s"} ` + q(2*time.Second, 0.99),
+			`api_latency_sum{api="version"} 60`,
+			`api_latency_count{api="version"} 3`,
+			"# HELP api_latency_max The max of observed values in trailing window.",
+			"# TYPE api_latency_max gauge",
+			`api_latency_max{api="version",window="1s"} ` + q(time.Second, 1),
+			`api_latency_max{api="version",window="2s"} ` + q(2*time.Second, 1),
+			"",
+		}, "\n")
+		if out := b.String(); !strings.HasPrefix(out, expect) {
+			t.Errorf("openMetrics=%v, invalid metrics %v, expect %v", openMetrics, out, expect)
+		}
+	}
+}
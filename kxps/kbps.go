@@ -25,6 +25,7 @@ package kxps
 import (
 	ol "github.com/ossrs/go-oryx-lib/logger"
 	"io"
+	"time"
 )
 
 // The source to stat the bitrate.
@@ -33,9 +34,20 @@ type KbpsSource interface {
 	TotalBytes() uint64
 }
 
+// The source which counter is 32bits, for example, the counter of 32bits system,
+// which wraparound to 0 after math.MaxUint32, and the wraparound is not counter reset.
+type kbpsSource32 struct {
+	KbpsSource
+}
+
+// Create the source for 32bits counter, see Kbps.Reset.
+func NewKbpsSource32(s KbpsSource) KbpsSource {
+	return &kbpsSource32{KbpsSource: s}
+}
+
 // The object to calc the kbps.
 type Kbps interface {
-	// Start the kbps sample goroutine.
+	// Start to sample by the DefaultRegistry, which own one goroutine for all objects.
 	Start() (err error)
 
 	// Get the kbps in last 10s.
@@ -47,7 +59,23 @@ type Kbps interface {
 	// Get the kbps in average
 	Average() float64
 
-	// When closed, this kbps should never use again.
+	// Get the kbps in last window, 0 if the window is not sampled.
+	Kbps(window time.Duration) float64
+	// Get the exponentially weighted moving average kbps of window,
+	// like the 1/5/15 minutes load average, 0 if the window is not sampled.
+	KbpsEwma(window time.Duration) float64
+	// Get the kbps of all windows and average at the same time, consistently.
+	Snapshot() Snapshot
+	// Add the alert on the kbps of window, checked when sampled.
+	AddAlert(a *Alert) error
+	// Reset to sample from now, for example, the source is swapped.
+	// @remark the reset of source counter is detected automatically,
+	// and the wraparound for 32bits counter, see NewKbpsSource32.
+	Reset() error
+	// The sampler to register to Registry, see Registry.Register.
+	Sampler
+
+	// When closed, this kbps is removed from registry and should never use again.
 	io.Closer
 }
 
@@ -56,12 +84,32 @@ type kbps struct {
 	imp    *kxps
 }
 
+// Create the kbps for 10s, 30s and 300s, sample every 10s.
 func NewKbps(ctx ol.Context, source KbpsSource) Kbps {
 	v := &kbps{source: source}
 	v.imp = newKxps(ctx, v)
 	return v
 }
 
+// Create the kbps for windows, sample every tick,
+// for example, tick 1s for windows 1s, 5s, 60s and 1h.
+func NewKbpsWindows(ctx ol.Context, source KbpsSource, tick time.Duration, windows ...time.Duration) Kbps {
+	return NewKbpsClock(ctx, source, RealClock, tick, windows...)
+}
+
+// Create the kbps with clock, for example, the FakeClock for test,
+// @remark the Registry to sample it should use the same clock, see NewRegistryClock.
+func NewKbpsClock(ctx ol.Context, source KbpsSource, clock Clock, tick time.Duration, windows ...time.Duration) Kbps {
+	v := &kbps{source: source}
+	v.imp = newKxpsWindows(ctx, v, clock, tick, windows)
+	return v
+}
+
+func (v *kbps) is32bits() bool {
+	_, ok := v.source.(*kbpsSource32)
+	return ok
+}
+
 func (v *kbps) Count() uint64 {
 	return v.source.TotalBytes()
 }
@@ -70,32 +118,44 @@ func (v *kbps) Close() (err error) {
 	return v.imp.Close()
 }
 
-func (v *kbps) Kbps10s() float64 {
-	if !v.imp.started {
+func (v *kbps) Kbps(window time.Duration) float64 {
+	if !v.imp.isStarted() {
 		panic("should start kbps first.")
 	}
 	// Bps to Kbps
-	return v.imp.Xps10s() * 8 / 1000
+	return v.imp.Xps(window) * 8 / 1000
 }
 
-func (v *kbps) Kbps30s() float64 {
-	if !v.imp.started {
+func (v *kbps) KbpsEwma(window time.Duration) float64 {
+	if !v.imp.isStarted() {
 		panic("should start kbps first.")
 	}
 	// Bps to Kbps
-	return v.imp.Xps30s() * 8 / 1000
+	return v.imp.Ewma(window) * 8 / 1000
+}
+
+func (v *kbps) Windows() []time.Duration {
+	return v.imp.Windows()
+}
+
+func (v *kbps) sampler() *kxps {
+	return v.imp
+}
+
+func (v *kbps) Kbps10s() float64 {
+	return v.Kbps(time.Duration(10) * time.Second)
+}
+
+func (v *kbps) Kbps30s() float64 {
+	return v.Kbps(time.Duration(30) * time.Second)
 }
 
 func (v *kbps) Kbps300s() float64 {
-	if !v.imp.started {
-		panic("should start kbps first.")
-	}
-	// Bps to Kbps
-	return v.imp.Xps300s() * 8 / 1000
+	return v.Kbps(time.Duration(300) * time.Second)
 }
 
 func (v *kbps) Average() float64 {
-	if !v.imp.started {
+	if !v.imp.isStarted() {
 		panic("should start kbps first.")
 	}
 	// Bps to Kbps
@@ -103,5 +163,23 @@ func (v *kbps) Average() float64 {
 }
 
 func (v *kbps) Start() (err error) {
-	return v.imp.Start()
+	return v.imp.Start(v)
+}
+
+func (v *kbps) Reset() error {
+	return v.imp.Reset()
+}
+
+func (v *kbps) Snapshot() Snapshot {
+	// Bps to Kbps
+	s, ok := v.imp.snapshot(8.0 / 1000)
+	if !ok {
+		panic("should start kbps first.")
+	}
+	return s
+}
+
+func (v *kbps) AddAlert(a *Alert) error {
+	// Bps to Kbps
+	return v.imp.addAlert(a, 8.0/1000)
 }
@@ -25,6 +25,7 @@ package kxps
 import (
 	ol "github.com/ossrs/go-oryx-lib/logger"
 	"io"
+	"time"
 )
 
 // The source to stat the requests.
@@ -33,9 +34,20 @@ type KrpsSource interface {
 	NbRequests() uint64
 }
 
+// The source which counter is 32bits, for example, the counter of 32bits system,
+// which wraparound to 0 after math.MaxUint32, and the wraparound is not counter reset.
+type krpsSource32 struct {
+	KrpsSource
+}
+
+// Create the source for 32bits counter, see Krps.Reset.
+func NewKrpsSource32(s KrpsSource) KrpsSource {
+	return &krpsSource32{KrpsSource: s}
+}
+
 // The object to calc the krps.
 type Krps interface {
-	// Start the krps sample goroutine.
+	// Start to sample by the DefaultRegistry, which own one goroutine for all objects.
 	Start() (err error)
 
 	// Get the rps in last 10s.
@@ -47,7 +59,23 @@ type Krps interface {
 	// Get the rps in average
 	Average() float64
 
-	// When closed, this krps should never use again.
+	// Get the rps in last window, 0 if the window is not sampled.
+	Rps(window time.Duration) float64
+	// Get the exponentially weighted moving average rps of window,
+	// like the 1/5/15 minutes load average, 0 if the window is not sampled.
+	RpsEwma(window time.Duration) float64
+	// Get the rps of all windows and average at the same time, consistently.
+	Snapshot() Snapshot
+	// Add the alert on the rps of window, checked when sampled.
+	AddAlert(a *Alert) error
+	// Reset to sample from now, for example, the source is swapped.
+	// @remark the reset of source counter is detected automatically,
+	// and the wraparound for 32bits counter, see NewKrpsSource32.
+	Reset() error
+	// The sampler to register to Registry, see Registry.Register.
+	Sampler
+
+	// When closed, this krps is removed from registry and should never use again.
 	io.Closer
 }
 
@@ -57,6 +85,7 @@ type krps struct {
 	imp    *kxps
 }
 
+// Create the krps for 10s, 30s and 300s, sample every 10s.
 func NewKrps(ctx ol.Context, s KrpsSource) Krps {
 	v := &krps{
 		source: s,
@@ -65,6 +94,27 @@ func NewKrps(ctx ol.Context, s KrpsSource) Krps {
 	return v
 }
 
+// Create the krps for windows, sample every tick,
+// for example, tick 1s for windows 1s, 5s, 60s and 1h.
+func NewKrpsWindows(ctx ol.Context, s KrpsSource, tick time.Duration, windows ...time.Duration) Krps {
+	return NewKrpsClock(ctx, s, RealClock, tick, windows...)
+}
+
+// Create the krps with clock, for example, the FakeClock for test,
+// @remark the Registry to sample it should use the same clock, see NewRegistryClock.
+func NewKrpsClock(ctx ol.Context, s KrpsSource, clock Clock, tick time.Duration, windows ...time.Duration) Krps {
+	v := &krps{
+		source: s,
+	}
+	v.imp = newKxpsWindows(ctx, v, clock, tick, windows)
+	return v
+}
+
+func (v *krps) is32bits() bool {
+	_, ok := v.source.(*krpsSource32)
+	return ok
+}
+
 func (v *krps) Count() uint64 {
 	return v.source.NbRequests()
 }
@@ -73,34 +123,63 @@ func (v *krps) Close() (err error) {
 	return v.imp.Close()
 }
 
-func (v *krps) Rps10s() float64 {
-	if !v.imp.started {
+func (v *krps) Rps(window time.Duration) float64 {
+	if !v.imp.isStarted() {
 		panic("should start krps first.")
 	}
-	return v.imp.Xps10s()
+	return v.imp.Xps(window)
 }
 
-func (v *krps) Rps30s() float64 {
-	if !v.imp.started {
+func (v *krps) RpsEwma(window time.Duration) float64 {
+	if !v.imp.isStarted() {
 		panic("should start krps first.")
 	}
-	return v.imp.Xps30s()
+	return v.imp.Ewma(window)
+}
+
+func (v *krps) Windows() []time.Duration {
+	return v.imp.Windows()
+}
+
+func (v *krps) sampler() *kxps {
+	return v.imp
+}
+
+func (v *krps) Rps10s() float64 {
+	return v.Rps(time.Duration(10) * time.Second)
+}
+
+func (v *krps) Rps30s() float64 {
+	return v.Rps(time.Duration(30) * time.Second)
 }
 
 func (v *krps) Rps300s() float64 {
-	if !v.imp.started {
-		panic("should start krps first.")
-	}
-	return v.imp.Xps300s()
+	return v.Rps(time.Duration(300) * time.Second)
 }
 
 func (v *krps) Average() float64 {
-	if !v.imp.started {
+	if !v.imp.isStarted() {
 		panic("should start krps first.")
 	}
 	return v.imp.Average()
 }
 
 func (v *krps) Start() (err error) {
-	return v.imp.Start()
+	return v.imp.Start(v)
+}
+
+func (v *krps) Reset() error {
+	return v.imp.Reset()
+}
+
+func (v *krps) Snapshot() Snapshot {
+	s, ok := v.imp.snapshot(1)
+	if !ok {
+		panic("should start krps first.")
+	}
+	return s
+}
+
+func (v *krps) AddAlert(a *Alert) error {
+	return v.imp.addAlert(a, 1)
 }
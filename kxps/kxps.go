@@ -25,6 +25,8 @@ package kxps
 import (
 	"fmt"
 	ol "github.com/ossrs/go-oryx-lib/logger"
+	"math"
+	"sort"
 	"sync"
 	"time"
 )
@@ -35,176 +37,432 @@ type kxpsSource interface {
 	Count() uint64
 }
 
-// sample for krps.
-type sample struct {
-	rps        float64
-	count      uint64
-	create     time.Time
-	lastSample time.Time
-	// Duration in seconds.
-	interval time.Duration
+// The source which counter is 32bits, which wraparound to 0 after math.MaxUint32,
+// see NewKbpsSource32 and NewKrpsSource32.
+type kxpsSource32 interface {
+	// Whether the counter is 32bits.
+	is32bits() bool
 }
 
-func (v *sample) String() string {
-	return fmt.Sprintf("rps=%v, count=%v, interval=%v", v.rps, v.count, v.interval)
+// The count sampled at a time.
+type point struct {
+	at    time.Time
+	count uint64
 }
 
-func (v *sample) initialize(now time.Time, nbRequests uint64) {
-	v.count = nbRequests
-	v.lastSample = now
-	v.create = now
+// The ring buffer of points, the oldest point is overwrote when full.
+type ring struct {
+	points []point
+	// the index of oldest point.
+	head int
+	size int
 }
 
-func (v *sample) sample(now time.Time, nbRequests uint64) bool {
-	if v.lastSample.Add(v.interval).After(now) {
-		return false
+func newRing(capacity int) *ring {
+	return &ring{points: make([]point, capacity)}
+}
+
+func (v *ring) reset() {
+	v.head, v.size = 0, 0
+}
+
+func (v *ring) push(p point) {
+	if v.size < len(v.points) {
+		v.points[(v.head+v.size)%len(v.points)] = p
+		v.size++
+		return
 	}
 
-	diff := int64(nbRequests - v.count)
-	v.count = nbRequests
-	v.lastSample = now
-	if diff <= 0 {
-		v.rps = 0
-		return true
+	v.points[v.head] = p
+	v.head = (v.head + 1) % len(v.points)
+}
+
+// Get the i-th point, 0 is the oldest.
+func (v *ring) get(i int) point {
+	return v.points[(v.head+i)%len(v.points)]
+}
+
+// Get the count at time t, interpolate between the points,
+// @return the oldest point when t before it.
+func (v *ring) countAt(t time.Time) point {
+	// find the newest point at or before t, the points are sorted by time.
+	i := sort.Search(v.size, func(i int) bool {
+		return v.get(i).at.After(t)
+	}) - 1
+
+	if i < 0 {
+		return v.get(0)
+	}
+
+	base := v.get(i)
+	if i == v.size-1 {
+		return base
+	}
+
+	// interpolate between the base and next point.
+	next := v.get(i + 1)
+	if next.count < base.count {
+		return base
+	}
+	ratio := float64(t.Sub(base.at)) / float64(next.at.Sub(base.at))
+	count := base.count + uint64(ratio*float64(next.count-base.count))
+
+	return point{at: t, count: count}
+}
+
+// The exponentially weighted moving average rate, like the load average of linux.
+type ewma struct {
+	window time.Duration
+	rate   float64
+	// whether the rate is initialized by the first sample.
+	initialized bool
+}
+
+func (v *ewma) update(rate float64, elapsed time.Duration) {
+	if !v.initialized {
+		v.rate, v.initialized = rate, true
+		return
 	}
 
-	interval := int(v.interval / time.Millisecond)
-	v.rps = float64(diff) * 1000 / float64(interval)
+	alpha := 1 - math.Exp(-float64(elapsed)/float64(v.window))
+	v.rate += alpha * (rate - v.rate)
+}
 
-	return true
+func (v *ewma) reset() {
+	v.rate, v.initialized = 0, false
 }
 
 var kxpsClosed = fmt.Errorf("kxps closed")
 
+// For 32bits source, when the count decrease from the upper quarter of uint32 to the lower quarter,
+// it's the wraparound of counter, otherwise the counter is reset.
+const wrapThreshold = uint64(1) << 30
+
+// The default sample tick and windows, for the 10s, 30s and 300s.
+const defaultTick = time.Duration(10) * time.Second
+
+var defaultWindows = []time.Duration{
+	time.Duration(10) * time.Second,
+	time.Duration(30) * time.Second,
+	time.Duration(300) * time.Second,
+}
+
+// The snapshot of rates, all windows are calculated at the same time.
+type Snapshot struct {
+	// The time of snapshot.
+	At time.Time
+	// The total count of source, for example, the bytes for kbps,
+	// which always increase even the counter of source is reset.
+	Count uint64
+	// The average rate since start.
+	Average float64
+	// The trailing rate of each window.
+	Rates map[time.Duration]float64
+	// The ewma rate of each window.
+	Ewmas map[time.Duration]float64
+}
+
 // The implementation object.
 type kxps struct {
 	// internal objects.
-	source  kxpsSource
-	ctx     ol.Context
-	closed  bool
+	source kxpsSource
+	ctx    ol.Context
+	clock  Clock
+	closed bool
+	// whether started, which is kept after unregistered, so the last rates are readable.
 	started bool
 	lock    *sync.Mutex
-	// samples
-	r10s  sample
-	r30s  sample
-	r300s sample
-	// for average
-	average uint64
-	create  time.Time
+	// the interval to sample.
+	tick time.Duration
+	// the windows to calc the trailing xps.
+	windows []time.Duration
+	// the sampled points, enough for the max window.
+	points *ring
+	// the ewma for each window.
+	ewmas []*ewma
+	// for average, the first sampled point.
+	first       point
+	initialized bool
+	// the last count of source, and the offset to rebase the count when reset or wraparound,
+	// so the sampled count always increase.
+	last   uint64
+	offset uint64
+	// the registry which sample this object, nil if not registered.
+	registry *Registry
+	key      string
+	// the callback when sampled, with lock held.
+	onSample func(now time.Time)
+	// the alerts checked when sampled.
+	alerts []*alertState
 }
 
 func newKxps(ctx ol.Context, s kxpsSource) *kxps {
+	return newKxpsWindows(ctx, s, RealClock, defaultTick, defaultWindows)
+}
+
+// Create the kxps which sample every tick, for each window.
+// @remark the tick should not larger than the min window, or the xps is less accurate.
+func newKxpsWindows(ctx ol.Context, s kxpsSource, clock Clock, tick time.Duration, windows []time.Duration) *kxps {
 	v := &kxps{
-		lock:   &sync.Mutex{},
-		source: s,
-		ctx:    ctx,
+		lock:    &sync.Mutex{},
+		source:  s,
+		ctx:     ctx,
+		clock:   clock,
+		tick:    tick,
+		windows: windows,
 	}
 
-	v.r10s.interval = time.Duration(10) * time.Second
-	v.r30s.interval = time.Duration(30) * time.Second
-	v.r300s.interval = time.Duration(300) * time.Second
+	var max time.Duration
+	for _, window := range windows {
+		if window > max {
+			max = window
+		}
+		v.ewmas = append(v.ewmas, &ewma{window: window})
+	}
+
+	// keep one more point for the window, and one for the interpolation.
+	capacity := 2
+	if tick > 0 {
+		capacity += int(max / tick)
+	}
+	v.points = newRing(capacity)
 
 	return v
 }
 
+// Get the windows to sample.
+func (v *kxps) Windows() []time.Duration {
+	return append([]time.Duration(nil), v.windows...)
+}
+
+// Close the object and remove it from registry.
 func (v *kxps) Close() (err error) {
 	v.lock.Lock()
 	defer v.lock.Unlock()
 
+	if v.registry != nil {
+		v.registry.remove(v.key)
+		v.registry, v.key = nil, ""
+	}
+
 	v.closed = true
 	v.started = false
 	return
 }
 
+// Whether the object is started, sampled by registry.
+func (v *kxps) isStarted() bool {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	return v.started
+}
+
+// Get the snapshot of all windows, the rates are multiplied by scale,
+// @return false when not started.
+func (v *kxps) snapshot(scale float64) (Snapshot, bool) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	now, count := v.clock.Now(), v.source.Count()
+
+	s := Snapshot{
+		At:      now,
+		Count:   v.rebase(count) + count,
+		Average: v.doAverage(now, count) * scale,
+		Rates:   make(map[time.Duration]float64),
+		Ewmas:   make(map[time.Duration]float64),
+	}
+	for _, window := range v.windows {
+		s.Rates[window] = v.doXps(now, count, window) * scale
+	}
+	for _, e := range v.ewmas {
+		s.Ewmas[e.window] = e.rate * scale
+	}
+
+	return s, v.started
+}
+
+// Get the trailing xps of window, 0 if the window is not sampled.
+func (v *kxps) Xps(window time.Duration) float64 {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	// read the source with lock, to compare with the last sampled count.
+	return v.doXps(v.clock.Now(), v.source.Count(), window)
+}
+
 func (v *kxps) Xps10s() float64 {
-	return v.r10s.rps
+	return v.Xps(time.Duration(10) * time.Second)
 }
 
 func (v *kxps) Xps30s() float64 {
-	return v.r30s.rps
+	return v.Xps(time.Duration(30) * time.Second)
 }
 
 func (v *kxps) Xps300s() float64 {
-	return v.r300s.rps
+	return v.Xps(time.Duration(300) * time.Second)
+}
+
+// Get the ewma xps of window, 0 if the window is not sampled.
+func (v *kxps) Ewma(window time.Duration) float64 {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	for _, e := range v.ewmas {
+		if e.window == window {
+			return e.rate
+		}
+	}
+	return 0
 }
 
 func (v *kxps) Average() float64 {
-	return v.sampleAverage(time.Now())
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	return v.doAverage(v.clock.Now(), v.source.Count())
 }
 
-func (v *kxps) sampleAverage(now time.Time) float64 {
-	if v.source.Count() == 0 {
-		return 0
+// Reset to sample from now, when the source is swapped, for example, the connection is recycled.
+// @remark the reset of counter is detected automatically, user only need to reset when the
+// count may not decrease, for example, the new counter increase faster than the old one.
+func (v *kxps) Reset() (err error) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	if v.closed {
+		return kxpsClosed
 	}
 
-	if v.average == 0 {
-		v.average = v.source.Count()
-		v.create = now
-		return 0
+	v.points.reset()
+	for _, e := range v.ewmas {
+		e.reset()
 	}
+	v.initialized = false
+	v.last, v.offset = 0, 0
 
-	diff := int64(v.source.Count() - v.average)
-	if diff <= 0 {
-		return 0
+	if v.started {
+		return v.doSample(v.clock.Now())
 	}
+	return
+}
 
-	duration := int64(now.Sub(v.create) / time.Millisecond)
-	if duration <= 0 {
+func (v *kxps) xps(now time.Time, count uint64, window time.Duration) float64 {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	return v.doXps(now, count, window)
+}
+
+// Calc the trailing xps of window at now, when the count is current count of source.
+// @remark when not enough points for window, use the oldest point.
+func (v *kxps) doXps(now time.Time, count uint64, window time.Duration) float64 {
+	if !v.sampled(window) || v.points.size == 0 {
 		return 0
 	}
 
-	return float64(diff) * 1000 / float64(duration)
+	base := v.points.countAt(now.Add(-window))
+	return rate(base, point{at: now, count: v.rebase(count) + count})
 }
 
-func (v *kxps) doSample(now time.Time) (err error) {
-	count := v.source.Count()
-	if count == 0 {
-		return
+// Whether the window is sampled.
+func (v *kxps) sampled(window time.Duration) bool {
+	for _, w := range v.windows {
+		if w == window {
+			return true
+		}
 	}
+	return false
+}
 
-	if v.r10s.count == 0 {
-		v.r10s.initialize(now, count)
-		v.r30s.initialize(now, count)
-		v.r300s.initialize(now, count)
-		return
+func (v *kxps) sampleAverage(now time.Time, count uint64) float64 {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	return v.doAverage(now, count)
+}
+
+func (v *kxps) doAverage(now time.Time, count uint64) float64 {
+	if !v.initialized {
+		return 0
 	}
 
-	if !v.r10s.sample(now, count) {
-		return
+	return rate(v.first, point{at: now, count: v.rebase(count) + count})
+}
+
+// Get the offset to rebase the count of source, which is changed when the count decrease.
+func (v *kxps) rebase(count uint64) uint64 {
+	if !v.initialized || count >= v.last {
+		return v.offset
 	}
 
-	if !v.r30s.sample(now, count) {
-		return
+	// The 32bits counter wraparound, for example, from 0xfffffff0 to 0x10.
+	if s, ok := v.source.(kxpsSource32); ok && s.is32bits() && v.last <= math.MaxUint32 && v.last > math.MaxUint32-wrapThreshold && count < wrapThreshold {
+		return v.offset + math.MaxUint32 + 1
 	}
 
-	if !v.r300s.sample(now, count) {
-		return
+	// The counter is reset, the count since reset is added to the last count.
+	return v.offset + v.last
+}
+
+// Calc the rate between two points, 0 when count decrease.
+func rate(from, to point) float64 {
+	if to.count <= from.count {
+		return 0
 	}
 
-	return
+	duration := int64(to.at.Sub(from.at) / time.Millisecond)
+	if duration <= 0 {
+		return 0
+	}
+
+	return float64(to.count-from.count) * 1000 / float64(duration)
 }
 
-func (v *kxps) Start() (err error) {
-	ctx := v.ctx
+func (v *kxps) doSample(now time.Time) (err error) {
+	count := v.source.Count()
+
+	if offset := v.rebase(count); offset != v.offset {
+		ol.T(v.ctx, "kxps rebase count from", v.last, "to", count, "offset from", v.offset, "to", offset)
+		v.offset = offset
+	}
+	v.last = count
+
+	p := point{at: now, count: v.offset + count}
+	if !v.initialized {
+		v.first, v.initialized = p, true
+	}
 
-	go func() {
-		for {
-			if err := v.sample(); err != nil {
-				if err == kxpsClosed {
-					return
-				}
-				ol.W(ctx, "kxps ignore sample failed, err is", err)
+	if v.points.size > 0 {
+		last := v.points.get(v.points.size - 1)
+		if elapsed := now.Sub(last.at); elapsed > 0 {
+			r := rate(last, p)
+			for _, e := range v.ewmas {
+				e.update(r, elapsed)
 			}
-			time.Sleep(time.Duration(10) * time.Second)
 		}
-	}()
+	}
+
+	v.points.push(p)
 
-	v.started = true
+	if v.onSample != nil {
+		v.onSample(now)
+	}
 
 	return
 }
 
-func (v *kxps) sample() (err error) {
+// Start to sample s by the DefaultRegistry, without name.
+// @remark user can use Registry.Register to sample with name and labels instead.
+// @remark fail for the object with other clock, use NewRegistryClock and Registry.Register instead.
+func (v *kxps) Start(s Sampler) (err error) {
+	return DefaultRegistry.add(fmt.Sprintf("#%p", v), "", nil, s)
+}
+
+// Sample at now when tick elapsed since last sample,
+// @param tolerance the duration to tolerate the jitter of ticker.
+func (v *kxps) sampleAt(now time.Time, tolerance time.Duration) (err error) {
 	ctx := v.ctx
 
 	defer func() {
@@ -213,6 +471,14 @@ func (v *kxps) sample() (err error) {
 		}
 	}()
 
+	// call the callbacks of alerts without lock.
+	var callbacks []func()
+	defer func() {
+		for _, callback := range callbacks {
+			callback()
+		}
+	}()
+
 	v.lock.Lock()
 	defer v.lock.Unlock()
 
@@ -220,5 +486,16 @@ func (v *kxps) sample() (err error) {
 		return kxpsClosed
 	}
 
-	return v.doSample(time.Now())
+	if v.points.size > 0 {
+		if last := v.points.get(v.points.size - 1); now.Sub(last.at) < v.tick-tolerance {
+			return
+		}
+	}
+
+	if err = v.doSample(now); err != nil {
+		return
+	}
+
+	callbacks = v.checkAlerts(now)
+	return
 }
@@ -22,6 +22,7 @@
 package kxps
 
 import (
+	"math"
 	"testing"
 	"time"
 )
@@ -38,19 +39,20 @@ func TestKxps_Average(t *testing.T) {
 	s := &mockSource{}
 	kxps := newKxps(nil, s)
 
-	if v := kxps.sampleAverage(time.Unix(0, 0)); v != 0 {
+	if v := kxps.sampleAverage(time.Unix(0, 0), 0); v != 0 {
 		t.Errorf("invalid average %v", v)
 	}
 
-	s.s = 10
-	if v := kxps.sampleAverage(time.Unix(10, 0)); v != 0 {
-		t.Errorf("invalid average %v", v)
+	// The average starts from the first sample, even the count is zero.
+	if err := kxps.doSample(time.Unix(0, 0)); err != nil {
+		t.Errorf("sample failed, err is %v", err)
 	}
 
-	s.s = 20
-	if v := kxps.sampleAverage(time.Unix(10, 0)); v != 0 {
+	if v := kxps.sampleAverage(time.Unix(0, 0), 0); v != 0 {
 		t.Errorf("invalid average %v", v)
-	} else if v := kxps.sampleAverage(time.Unix(20, 0)); v != 10.0/10.0 {
+	} else if v := kxps.sampleAverage(time.Unix(10, 0), 10); v != 10.0/10.0 {
+		t.Errorf("invalid average %v", v)
+	} else if v := kxps.sampleAverage(time.Unix(20, 0), 30); v != 30.0/20.0 {
 		t.Errorf("invalid average %v", v)
 	}
 }
@@ -59,57 +61,238 @@ func TestKxps_Rps10s(t *testing.T) {
 	s := &mockSource{}
 	kxps := newKxps(nil, s)
 
-	if err := kxps.doSample(time.Unix(0, 0)); err != nil {
-		t.Errorf("sample failed, err is", err)
-	} else if kxps.Xps10s() != 0 || kxps.Xps30s() != 0 || kxps.Xps300s() != 0 {
-		t.Errorf("sample invalid, 10s=%v, 30s=%v, 300s=%v", kxps.Xps10s(), kxps.Xps30s(), kxps.Xps300s())
+	if v := kxps.xps(time.Unix(0, 0), 0, 10*time.Second); v != 0 {
+		t.Errorf("sample invalid, 10s=%v", v)
 	}
 
-	s.s = 10
-	if err := kxps.doSample(time.Unix(10, 0)); err != nil {
-		t.Errorf("sample failed, err is", err)
-	} else if kxps.Xps10s() != 0 || kxps.Xps30s() != 0 || kxps.Xps300s() != 0 {
-		t.Errorf("sample invalid, 10s=%v, 30s=%v, 300s=%v", kxps.Xps10s(), kxps.Xps30s(), kxps.Xps300s())
+	for i := 0; i <= 2; i++ {
+		s.s = uint64(i * 10)
+		if err := kxps.doSample(time.Unix(int64(i*10), 0)); err != nil {
+			t.Errorf("sample failed, err is %v", err)
+		}
+	}
+
+	if v := kxps.xps(time.Unix(20, 0), 20, 10*time.Second); v != 10.0/10.0 {
+		t.Errorf("sample invalid, 10s=%v", v)
+	} else if v := kxps.xps(time.Unix(20, 0), 20, 30*time.Second); v != 20.0/20.0 {
+		t.Errorf("sample invalid, 30s=%v", v)
+	}
+
+	// The trailing xps at any moment, between the samples.
+	if v := kxps.xps(time.Unix(25, 0), 25, 10*time.Second); v != 10.0/10.0 {
+		t.Errorf("sample invalid, 10s=%v", v)
+	} else if v := kxps.xps(time.Unix(25, 0), 20, 10*time.Second); v != 5.0/10.0 {
+		t.Errorf("sample invalid, 10s=%v", v)
 	}
 
-	s.s = 20
-	if err := kxps.doSample(time.Unix(20, 0)); err != nil {
-		t.Errorf("sample failed, err is", err)
-	} else if kxps.Xps10s() != 10.0/10.0 || kxps.Xps30s() != 0 || kxps.Xps300s() != 0 {
-		t.Errorf("sample invalid, 10s=%v, 30s=%v, 300s=%v", kxps.Xps10s(), kxps.Xps30s(), kxps.Xps300s())
-	} else if err := kxps.doSample(time.Unix(30, 0)); err != nil {
-		t.Errorf("sample failed, err is", err)
-	} else if kxps.Xps10s() != 0 || kxps.Xps30s() != 0 || kxps.Xps300s() != 0 {
-		t.Errorf("sample invalid, 10s=%v, 30s=%v, 300s=%v", kxps.Xps10s(), kxps.Xps30s(), kxps.Xps300s())
+	if err := kxps.doSample(time.Unix(30, 0)); err != nil {
+		t.Errorf("sample failed, err is %v", err)
+	} else if v := kxps.xps(time.Unix(30, 0), 20, 10*time.Second); v != 0 {
+		t.Errorf("sample invalid, 10s=%v", v)
+	} else if v := kxps.xps(time.Unix(30, 0), 20, 30*time.Second); v != 20.0/30.0 {
+		t.Errorf("sample invalid, 30s=%v", v)
 	}
 
 	s.s = 30
 	if err := kxps.doSample(time.Unix(40, 0)); err != nil {
-		t.Errorf("sample failed, err is", err)
-	} else if kxps.Xps10s() != 10.0/10.0 || kxps.Xps30s() != 20.0/30.0 || kxps.Xps300s() != 0 {
-		t.Errorf("sample invalid, 10s=%v, 30s=%v, 300s=%v", kxps.Xps10s(), kxps.Xps30s(), kxps.Xps300s())
-	} else if err := kxps.doSample(time.Unix(50, 0)); err != nil {
-		t.Errorf("sample failed, err is", err)
-	} else if kxps.Xps10s() != 0 || kxps.Xps30s() != 20.0/30.0 || kxps.Xps300s() != 0 {
-		t.Errorf("sample invalid, 10s=%v, 30s=%v, 300s=%v", kxps.Xps10s(), kxps.Xps30s(), kxps.Xps300s())
-	}
-
-	s.s = 40
-	if err := kxps.doSample(time.Unix(310, 0)); err != nil {
-		t.Errorf("sample failed, err is", err)
-	} else if kxps.Xps10s() != 10.0/10.0 || kxps.Xps30s() != 10.0/30.0 || kxps.Xps300s() != 30.0/300.0 {
-		t.Errorf("sample invalid, 10s=%v, 30s=%v, 300s=%v", kxps.Xps10s(), kxps.Xps30s(), kxps.Xps300s())
-	} else if err := kxps.doSample(time.Unix(320, 0)); err != nil {
-		t.Errorf("sample failed, err is", err)
-	} else if kxps.Xps10s() != 0 || kxps.Xps30s() != 10.0/30.0 || kxps.Xps300s() != 30.0/300.0 {
-		t.Errorf("sample invalid, 10s=%v, 30s=%v, 300s=%v", kxps.Xps10s(), kxps.Xps30s(), kxps.Xps300s())
-	} else if err := kxps.doSample(time.Unix(340, 0)); err != nil {
-		t.Errorf("sample failed, err is", err)
-	} else if kxps.Xps10s() != 0 || kxps.Xps30s() != 0 || kxps.Xps300s() != 30.0/300.0 {
-		t.Errorf("sample invalid, 10s=%v, 30s=%v, 300s=%v", kxps.Xps10s(), kxps.Xps30s(), kxps.Xps300s())
-	} else if err := kxps.doSample(time.Unix(610, 0)); err != nil {
-		t.Errorf("sample failed, err is", err)
-	} else if kxps.Xps10s() != 0 || kxps.Xps30s() != 0 || kxps.Xps300s() != 0 {
-		t.Errorf("sample invalid, 10s=%v, 30s=%v, 300s=%v", kxps.Xps10s(), kxps.Xps30s(), kxps.Xps300s())
+		t.Errorf("sample failed, err is %v", err)
+	} else if v := kxps.xps(time.Unix(40, 0), 30, 10*time.Second); v != 10.0/10.0 {
+		t.Errorf("sample invalid, 10s=%v", v)
+	} else if v := kxps.xps(time.Unix(40, 0), 30, 30*time.Second); v != 20.0/30.0 {
+		t.Errorf("sample invalid, 30s=%v", v)
+	} else if v := kxps.xps(time.Unix(40, 0), 30, 300*time.Second); v != 30.0/40.0 {
+		t.Errorf("sample invalid, 300s=%v", v)
+	}
+
+	// Overwrite the oldest points.
+	for i := 5; i <= 100; i++ {
+		s.s = uint64(i * 10)
+		if err := kxps.doSample(time.Unix(int64(i*10), 0)); err != nil {
+			t.Errorf("sample failed, err is %v", err)
+		}
+	}
+
+	if v := kxps.xps(time.Unix(1000, 0), 1000, 300*time.Second); v != 1 {
+		t.Errorf("sample invalid, 300s=%v", v)
+	} else if v := kxps.xps(time.Unix(1000, 0), 1000, 20*time.Second); v != 0 {
+		t.Errorf("window not sampled, 20s=%v", v)
+	}
+}
+
+func TestKxps_Windows(t *testing.T) {
+	s := &mockSource{}
+	kxps := newKxpsWindows(nil, s, RealClock, time.Second, []time.Duration{time.Second, 5 * time.Second})
+
+	if w := kxps.Windows(); len(w) != 2 || w[0] != time.Second || w[1] != 5*time.Second {
+		t.Errorf("invalid windows %v", w)
+	}
+
+	for i := 0; i <= 5; i++ {
+		s.s = uint64(10 + i*10)
+		if err := kxps.doSample(time.Unix(int64(i), 0)); err != nil {
+			t.Errorf("sample failed, err is %v", err)
+		} else if v := kxps.xps(time.Unix(int64(i), 0), s.s, time.Second); i > 0 && v != 10 {
+			t.Errorf("sample invalid, 1s=%v", v)
+		}
+	}
+
+	if v := kxps.xps(time.Unix(5, 0), 60, 5*time.Second); v != 50.0/5.0 {
+		t.Errorf("sample invalid, 5s=%v", v)
+	}
+}
+
+func TestKxps_Ewma(t *testing.T) {
+	s := &mockSource{}
+	kxps := newKxpsWindows(nil, s, RealClock, time.Second, []time.Duration{time.Minute})
+
+	for i := 0; i <= 60; i++ {
+		s.s = uint64(i * 10)
+		if err := kxps.doSample(time.Unix(int64(i), 0)); err != nil {
+			t.Errorf("sample failed, err is %v", err)
+		}
+	}
+
+	if v := kxps.Ewma(time.Minute); v != 10 {
+		t.Errorf("invalid ewma %v", v)
+	}
+
+	// The ewma decay to 1/e after a window without increasing.
+	for i := 61; i <= 120; i++ {
+		if err := kxps.doSample(time.Unix(int64(i), 0)); err != nil {
+			t.Errorf("sample failed, err is %v", err)
+		}
+	}
+
+	if v := kxps.Ewma(time.Minute); math.Abs(v-10*math.Exp(-1)) > 0.001 {
+		t.Errorf("invalid ewma %v", v)
+	} else if v := kxps.Ewma(time.Second); v != 0 {
+		t.Errorf("window not sampled, ewma %v", v)
+	}
+}
+
+func TestKxps_CounterReset(t *testing.T) {
+	s := &mockSource{}
+	kxps := newKxpsWindows(nil, s, RealClock, time.Second, []time.Duration{time.Second, 5 * time.Second})
+
+	for i := 0; i <= 2; i++ {
+		s.s = uint64(100 + i*10)
+		if err := kxps.doSample(time.Unix(int64(i), 0)); err != nil {
+			t.Errorf("sample failed, err is %v", err)
+		}
+	}
+
+	// The counter is reset from 120 to 5, rebase to 125.
+	s.s = 5
+	if v := kxps.xps(time.Unix(3, 0), s.s, time.Second); v != 5 {
+		t.Errorf("sample invalid, 1s=%v", v)
+	} else if err := kxps.doSample(time.Unix(3, 0)); err != nil {
+		t.Errorf("sample failed, err is %v", err)
+	} else if kxps.offset != 120 {
+		t.Errorf("invalid offset %v", kxps.offset)
+	}
+
+	s.s = 15
+	if err := kxps.doSample(time.Unix(4, 0)); err != nil {
+		t.Errorf("sample failed, err is %v", err)
+	} else if v := kxps.xps(time.Unix(4, 0), s.s, time.Second); v != 10 {
+		t.Errorf("sample invalid, 1s=%v", v)
+	} else if v := kxps.xps(time.Unix(4, 0), s.s, 5*time.Second); v != 35.0/4.0 {
+		t.Errorf("sample invalid, 5s=%v", v)
+	} else if v := kxps.sampleAverage(time.Unix(5, 0), s.s); v != 35.0/5.0 {
+		t.Errorf("invalid average %v", v)
+	}
+}
+
+// The source of 32bits counter.
+type mockSource32 struct {
+	mockSource
+}
+
+func (v *mockSource32) is32bits() bool {
+	return true
+}
+
+func TestKxps_CounterWraparound(t *testing.T) {
+	s := &mockSource32{mockSource{s: math.MaxUint32 - 9}}
+	kxps := newKxpsWindows(nil, s, RealClock, time.Second, []time.Duration{time.Second})
+
+	if err := kxps.doSample(time.Unix(0, 0)); err != nil {
+		t.Errorf("sample failed, err is %v", err)
+	}
+
+	// The 32bits counter wraparound, increase 20.
+	s.s = 10
+	if err := kxps.doSample(time.Unix(1, 0)); err != nil {
+		t.Errorf("sample failed, err is %v", err)
+	} else if v := kxps.xps(time.Unix(1, 0), s.s, time.Second); v != 20 {
+		t.Errorf("sample invalid, 1s=%v", v)
+	} else if v := kxps.Ewma(time.Second); v != 20 {
+		t.Errorf("invalid ewma %v", v)
+	}
+}
+
+func TestKxps_CounterReset64(t *testing.T) {
+	// The 64bits counter at 3.5GiB, which is never wraparound.
+	s := &mockSource{s: 7 << 29}
+	kxps := newKxpsWindows(nil, s, RealClock, time.Second, []time.Duration{time.Second})
+
+	if err := kxps.doSample(time.Unix(0, 0)); err != nil {
+		t.Errorf("sample failed, err is %v", err)
+	}
+
+	// The counter is reset, rebase to the last count, no phantom bytes.
+	s.s = 10
+	if err := kxps.doSample(time.Unix(1, 0)); err != nil {
+		t.Errorf("sample failed, err is %v", err)
+	} else if kxps.offset != 7<<29 {
+		t.Errorf("invalid offset %v", kxps.offset)
+	} else if v := kxps.xps(time.Unix(1, 0), s.s, time.Second); v != 10 {
+		t.Errorf("sample invalid, 1s=%v", v)
+	}
+}
+
+func TestNewKbpsSource32(t *testing.T) {
+	s := &mockKbpsSource{}
+	if v := NewKbps(nil, s).(*kbps); v.is32bits() {
+		t.Errorf("should not be 32bits")
+	} else if v := NewKbps(nil, NewKbpsSource32(s)).(*kbps); !v.is32bits() {
+		t.Errorf("should be 32bits")
+	}
+}
+
+func TestKxps_Reset(t *testing.T) {
+	s := &mockSource{}
+	kxps := newKxpsWindows(nil, s, RealClock, time.Second, []time.Duration{time.Second})
+
+	for i := 0; i <= 2; i++ {
+		s.s = uint64(i * 10)
+		if err := kxps.doSample(time.Unix(int64(i), 0)); err != nil {
+			t.Errorf("sample failed, err is %v", err)
+		}
+	}
+
+	// Not started, reset without sample.
+	if err := kxps.Reset(); err != nil {
+		t.Errorf("reset failed, err is %v", err)
+	} else if kxps.points.size != 0 || kxps.initialized {
+		t.Errorf("invalid size=%v, initialized=%v", kxps.points.size, kxps.initialized)
+	} else if v := kxps.Ewma(time.Second); v != 0 {
+		t.Errorf("invalid ewma %v", v)
+	} else if v := kxps.sampleAverage(time.Unix(3, 0), 30); v != 0 {
+		t.Errorf("invalid average %v", v)
+	}
+
+	// Started, sample from now.
+	kxps.started = true
+	if err := kxps.Reset(); err != nil {
+		t.Errorf("reset failed, err is %v", err)
+	} else if kxps.points.size != 1 || kxps.first.count != 20 {
+		t.Errorf("invalid size=%v, first=%v", kxps.points.size, kxps.first.count)
+	}
+
+	if err := kxps.Close(); err != nil {
+		t.Errorf("close failed, err is %v", err)
+	} else if err := kxps.Reset(); err != kxpsClosed {
+		t.Errorf("should fail for closed, err is %v", err)
 	}
 }
@@ -0,0 +1,325 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2013-2016 Oryx(ossrs)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// The prometheus and openmetrics exposition for kxps.
+package kxps
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	oh "github.com/ossrs/go-oryx-lib/http"
+	ol "github.com/ossrs/go-oryx-lib/logger"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// header["Content-Type"] of metrics.
+const (
+	MetricsPrometheus  = "text/plain; version=0.0.4; charset=utf-8"
+	MetricsOpenMetrics = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+)
+
+// The metric type.
+const (
+	metricGauge   = "gauge"
+	metricCounter = "counter"
+	metricSummary = "summary"
+)
+
+// The start time of process, for process_start_time_seconds.
+var processStartTime = time.Now()
+
+// A sample of metric family.
+type metric struct {
+	// the suffix of sample name, for example, _sum and _count of summary.
+	suffix string
+	labels Labels
+	value  float64
+}
+
+// The metric family, all samples with the same name.
+// @remark for counter, the name is without the _total suffix.
+type family struct {
+	name    string
+	typ     string
+	help    string
+	metrics []metric
+}
+
+// The metric families, in the order of added.
+type families struct {
+	list  []*family
+	index map[string]*family
+}
+
+func newFamilies() *families {
+	return &families{index: make(map[string]*family)}
+}
+
+func (v *families) add(name, typ, help string, labels Labels, value float64) {
+	v.addSample(name, "", typ, help, labels, value)
+}
+
+// Add the sample with suffix of name to family, for example, the _sum of summary.
+func (v *families) addSample(name, suffix, typ, help string, labels Labels, value float64) {
+	f, ok := v.index[name]
+	if !ok {
+		f = &family{name: name, typ: typ, help: help}
+		v.index[name] = f
+		v.list = append(v.list, f)
+	}
+	f.metrics = append(f.metrics, metric{suffix: suffix, labels: labels, value: value})
+}
+
+// Write the families in prometheus text format, or openmetrics text format.
+func (v *families) write(w io.Writer, openMetrics bool) error {
+	b := bufio.NewWriter(w)
+
+	for _, f := range v.list {
+		name, sample := f.name, f.name
+		if f.typ == metricCounter {
+			// the family name of counter is without _total for openmetrics.
+			if sample = f.name + "_total"; !openMetrics {
+				name = sample
+			}
+		}
+
+		fmt.Fprintf(b, "# HELP %v %v\n", name, f.help)
+		fmt.Fprintf(b, "# TYPE %v %v\n", name, f.typ)
+		for _, m := range f.metrics {
+			fmt.Fprintf(b, "%v%v%v %v\n", sample, m.suffix, formatLabels(m.labels), formatValue(m.value))
+		}
+	}
+
+	if openMetrics {
+		b.WriteString("# EOF\n")
+	}
+
+	return b.Flush()
+}
+
+// Format the labels as {k0="v0",k1="v1"} sorted by key, escape the value.
+func formatLabels(labels Labels) string {
+	if len(labels) == 0 {
+		return ""
+	}
+
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b bytes.Buffer
+	b.WriteString("{")
+	for i, k := range keys {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		b.WriteString(metricName(k))
+		b.WriteString(`="`)
+		b.WriteString(labelEscaper.Replace(labels[k]))
+		b.WriteString(`"`)
+	}
+	b.WriteString("}")
+
+	return b.String()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Convert the name to valid metric name, the invalid char is replaced by _.
+func metricName(name string) string {
+	b := []byte(name)
+	for i, c := range b {
+		if c == '_' || c == ':' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (i > 0 && c >= '0' && c <= '9') {
+			continue
+		}
+		b[i] = '_'
+	}
+	return string(b)
+}
+
+// Copy the labels with the window label.
+func withWindow(labels Labels, window time.Duration) Labels {
+	v := make(Labels, len(labels)+1)
+	for k, l := range labels {
+		v[k] = l
+	}
+	v["window"] = window.String()
+	return v
+}
+
+// Collect the metrics of all named objects, the object registered without name is ignored.
+func (v *Registry) collect(fs *families) {
+	for _, e := range v.named() {
+		if h, ok := e.sampler.(*histogram); ok {
+			collectHistogram(fs, metricName(e.name), e.labels, h)
+			continue
+		}
+
+		// For kbps, the bytes per seconds to kbps.
+		unit, total, scale := "rps", "requests", float64(1)
+		if _, ok := e.sampler.(Kbps); ok {
+			unit, total, scale = "kbps", "bytes", float64(8)/1000
+		}
+
+		// all windows at the same time, ignore when closed.
+		snapshot, ok := e.sampler.sampler().snapshot(scale)
+		if !ok {
+			continue
+		}
+
+		windows := e.sampler.Windows()
+		name := metricName(e.name)
+
+		for _, window := range windows {
+			fs.add(name+"_"+unit, metricGauge, fmt.Sprintf("The %v in trailing window.", unit),
+				withWindow(e.labels, window), snapshot.Rates[window])
+		}
+		for _, window := range windows {
+			fs.add(name+"_"+unit+"_ewma", metricGauge, fmt.Sprintf("The exponentially weighted moving average %v of window.", unit),
+				withWindow(e.labels, window), snapshot.Ewmas[window])
+		}
+		fs.add(name+"_"+unit+"_average", metricGauge, fmt.Sprintf("The average %v since start.", unit),
+			e.labels, snapshot.Average)
+		fs.add(name+"_"+total, metricCounter, fmt.Sprintf("The total number of %v.", total),
+			e.labels, float64(snapshot.Count))
+	}
+}
+
+// Collect the histogram as summary, the quantiles of each window with the window label,
+// and the total sum and count once without the window label, for they are not in window,
+// and the max in window.
+func collectHistogram(fs *families, name string, labels Labels, h *histogram) {
+	now := h.imp.clock.Now()
+	count, sum := h.Count(), h.Sum()
+
+	help := "The quantiles of observed values in trailing window, with total sum and count."
+	for _, window := range h.Windows() {
+		for _, q := range []float64{0.5, 0.9, 0.99} {
+			l := withWindow(labels, window)
+			l["quantile"] = formatValue(q)
+			fs.add(name, metricSummary, help, l, h.quantile(now, window, q))
+		}
+	}
+	fs.addSample(name, "_sum", metricSummary, help, labels, sum)
+	fs.addSample(name, "_count", metricSummary, help, labels, float64(count))
+
+	for _, window := range h.Windows() {
+		fs.add(name+"_max", metricGauge, "The max of observed values in trailing window.",
+			withWindow(labels, window), h.quantile(now, window, 1))
+	}
+}
+
+// Collect the metrics of process and go runtime.
+func collectProcess(fs *families) {
+	fs.add("process_start_time_seconds", metricGauge, "Start time of the process since unix epoch in seconds.",
+		nil, float64(processStartTime.UnixNano())/1e9)
+
+	// The stat of linux, ignore for other os.
+	if b, err := ioutil.ReadFile("/proc/self/stat"); err == nil {
+		// the fields after the command, which is in parentheses and may contain spaces.
+		if pos := bytes.LastIndexByte(b, ')'); pos > 0 {
+			fields := strings.Fields(string(b[pos+1:]))
+			// utime and stime are the 14th and 15th fields, in clock ticks of 100HZ.
+			if len(fields) > 12 {
+				utime, _ := strconv.ParseFloat(fields[11], 64)
+				stime, _ := strconv.ParseFloat(fields[12], 64)
+				fs.add("process_cpu_seconds", metricCounter, "Total user and system CPU time spent in seconds.",
+					nil, (utime+stime)/100)
+			}
+		}
+	}
+	if b, err := ioutil.ReadFile("/proc/self/statm"); err == nil {
+		if fields := strings.Fields(string(b)); len(fields) > 1 {
+			rss, _ := strconv.ParseFloat(fields[1], 64)
+			fs.add("process_resident_memory_bytes", metricGauge, "Resident memory size in bytes.",
+				nil, rss*float64(os.Getpagesize()))
+		}
+	}
+	if fds, err := ioutil.ReadDir("/proc/self/fd"); err == nil {
+		fs.add("process_open_fds", metricGauge, "Number of open file descriptors.",
+			nil, float64(len(fds)))
+	}
+
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+
+	fs.add("go_goroutines", metricGauge, "Number of goroutines that currently exist.",
+		nil, float64(runtime.NumGoroutine()))
+	fs.add("go_memstats_alloc_bytes", metricGauge, "Number of bytes allocated and still in use.",
+		nil, float64(ms.Alloc))
+	fs.add("go_memstats_sys_bytes", metricGauge, "Number of bytes obtained from system.",
+		nil, float64(ms.Sys))
+	fs.add("go_memstats_heap_objects", metricGauge, "Number of allocated objects.",
+		nil, float64(ms.HeapObjects))
+	fs.add("go_memstats_gc", metricCounter, "Number of completed GC cycles.",
+		nil, float64(ms.NumGC))
+}
+
+// Write the metrics of registry and process to w,
+// in prometheus text format, or openmetrics text format when openMetrics.
+func (v *Registry) WriteMetrics(w io.Writer, openMetrics bool) error {
+	fs := newFamilies()
+
+	v.collect(fs)
+	collectProcess(fs)
+
+	return fs.write(w, openMetrics)
+}
+
+// The http handler to expose the metrics of registry and process,
+// in openmetrics format when the request accepts application/openmetrics-text,
+// otherwise in prometheus text format.
+// @remark use the DefaultRegistry when r is nil.
+func NewMetricsHandler(r *Registry) http.Handler {
+	if r == nil {
+		r = DefaultRegistry
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		openMetrics := strings.Contains(req.Header.Get("Accept"), "application/openmetrics-text")
+
+		oh.SetHeader(w)
+		if openMetrics {
+			w.Header().Set("Content-Type", MetricsOpenMetrics)
+		} else {
+			w.Header().Set("Content-Type", MetricsPrometheus)
+		}
+
+		if err := r.WriteMetrics(w, openMetrics); err != nil {
+			ol.W(r.ctx, "kxps write metrics failed, err is", err)
+		}
+	})
+}
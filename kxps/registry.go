@@ -0,0 +1,253 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2013-2016 Oryx(ossrs)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// The registry for kxps.
+package kxps
+
+import (
+	"fmt"
+	ol "github.com/ossrs/go-oryx-lib/logger"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The labels of source, for example, {"app": "live", "stream": "livestream"}.
+type Labels map[string]string
+
+// Format the labels as {k0="v0",k1="v1"} sorted by key, empty string for no label.
+func (v Labels) String() string {
+	if len(v) == 0 {
+		return ""
+	}
+
+	keys := make([]string, 0, len(v))
+	for k := range v {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, fmt.Sprintf("%v=%q", k, v[k]))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// The object sampled by registry, for example, the Kbps or Krps.
+type Sampler interface {
+	// Get the windows to sample.
+	Windows() []time.Duration
+
+	// Get the implementation object.
+	sampler() *kxps
+}
+
+// The registered sampler.
+type entry struct {
+	name    string
+	labels  Labels
+	sampler Sampler
+}
+
+// The registry which own a ticker to sample all registered objects in one pass,
+// to avoid a goroutine and timer for each object.
+// @remark the object is sampled by its tick, so the tick of registry should not larger than it.
+type Registry struct {
+	ctx   ol.Context
+	clock Clock
+	tick  time.Duration
+	lock  *sync.Mutex
+	// the registered objects, key is name and labels.
+	entries map[string]*entry
+	// to stop the sample goroutine, which quit when no entry.
+	stop func()
+}
+
+// The default registry, the Start() of Kbps and Krps register to it.
+var DefaultRegistry = NewRegistry(nil, time.Second)
+
+func NewRegistry(ctx ol.Context, tick time.Duration) *Registry {
+	return NewRegistryClock(ctx, RealClock, tick)
+}
+
+// Create the registry with clock, for example, the FakeClock for test,
+// the objects are sampled when FakeClock.Advance or Tick.
+func NewRegistryClock(ctx ol.Context, clock Clock, tick time.Duration) *Registry {
+	return &Registry{
+		ctx:     ctx,
+		clock:   clock,
+		tick:    tick,
+		lock:    &sync.Mutex{},
+		entries: make(map[string]*entry),
+	}
+}
+
+// Register the object with name and labels, it's sampled until Unregister or Close.
+func (v *Registry) Register(name string, labels Labels, s Sampler) error {
+	return v.add(name+labels.String(), name, labels, s)
+}
+
+// Unregister the object by name and labels, the object is not sampled any more,
+// but the last rates are still readable, and it can be registered again.
+func (v *Registry) Unregister(name string, labels Labels) error {
+	key := name + labels.String()
+
+	v.lock.Lock()
+	e, ok := v.entries[key]
+	v.lock.Unlock()
+
+	if !ok {
+		return fmt.Errorf("kxps %v not registered", key)
+	}
+
+	imp := e.sampler.sampler()
+	imp.lock.Lock()
+	defer imp.lock.Unlock()
+
+	v.remove(key)
+	imp.registry, imp.key = nil, ""
+	return nil
+}
+
+// Add the object to registry with the key.
+func (v *Registry) add(key, name string, labels Labels, s Sampler) error {
+	imp := s.sampler()
+
+	imp.lock.Lock()
+	defer imp.lock.Unlock()
+
+	if imp.closed {
+		return kxpsClosed
+	}
+	if imp.registry != nil {
+		return fmt.Errorf("kxps %v already registered as %v", key, imp.key)
+	}
+	// the object is sampled by the clock of registry, which should be the same clock.
+	if imp.clock != v.clock {
+		return fmt.Errorf("kxps %v clock should be the same as registry", key)
+	}
+
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	if _, ok := v.entries[key]; ok {
+		return fmt.Errorf("kxps %v already registered", key)
+	}
+	v.entries[key] = &entry{name: name, labels: labels, sampler: s}
+
+	imp.registry, imp.key = v, key
+	imp.started = true
+
+	// sample the first point immediately.
+	if err := imp.doSample(imp.clock.Now()); err != nil {
+		delete(v.entries, key)
+		imp.registry, imp.key = nil, ""
+		imp.started = false
+		return err
+	}
+
+	if v.stop == nil {
+		v.stop = v.clock.Every(v.tick, v.cycle)
+	}
+
+	return nil
+}
+
+func (v *Registry) remove(key string) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	delete(v.entries, key)
+}
+
+// The number of registered objects.
+func (v *Registry) Len() int {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	return len(v.entries)
+}
+
+// Get the registered objects.
+func (v *Registry) snapshot() []*entry {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	entries := make([]*entry, 0, len(v.entries))
+	for _, e := range v.entries {
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+// Get the objects registered with name, sorted by name and labels.
+func (v *Registry) named() []*entry {
+	entries := make(map[string]*entry)
+	var keys []string
+	for _, e := range v.snapshot() {
+		if e.name != "" {
+			key := e.name + e.labels.String()
+			entries[key] = e
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	named := make([]*entry, 0, len(keys))
+	for _, key := range keys {
+		named = append(named, entries[key])
+	}
+	return named
+}
+
+// Sample all objects every tick, stop when no entry.
+func (v *Registry) cycle(now time.Time) {
+	v.lock.Lock()
+	if len(v.entries) == 0 {
+		stop := v.stop
+		v.stop = nil
+		v.lock.Unlock()
+
+		if stop != nil {
+			stop()
+		}
+		return
+	}
+	v.lock.Unlock()
+
+	v.sampleAll(now)
+}
+
+// Sample all objects now manually, the object is sampled only when its tick elapsed.
+func (v *Registry) Tick() {
+	v.sampleAll(v.clock.Now())
+}
+
+// Sample all objects in one pass, each object is sampled when its tick elapsed.
+func (v *Registry) sampleAll(now time.Time) {
+	for _, e := range v.snapshot() {
+		if err := e.sampler.sampler().sampleAt(now, v.tick/2); err != nil && err != kxpsClosed {
+			ol.W(v.ctx, "kxps ignore sample", e.name, e.labels, "failed, err is", err)
+		}
+	}
+}
@@ -0,0 +1,444 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2013-2016 Oryx(ossrs)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package kxps
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type mockKbpsSource struct {
+	bytes uint64
+}
+
+func (v *mockKbpsSource) TotalBytes() uint64 {
+	return v.bytes
+}
+
+func TestLabels_String(t *testing.T) {
+	if v := Labels(nil).String(); v != "" {
+		t.Errorf("invalid labels %v", v)
+	}
+
+	if v := (Labels{"stream": "livestream", "app": "live"}).String(); v != `{app="live",stream="livestream"}` {
+		t.Errorf("invalid labels %v", v)
+	}
+}
+
+func TestRegistry_Register(t *testing.T) {
+	r := NewRegistry(nil, time.Second)
+
+	s0, s1 := &mockKbpsSource{}, &mockKbpsSource{}
+	k0 := NewKbpsWindows(nil, s0, time.Second, time.Second)
+	k1 := NewKbpsWindows(nil, s1, 2*time.Second, 2*time.Second)
+
+	if err := r.Register("conn", Labels{"id": "0"}, k0); err != nil {
+		t.Errorf("register failed, err is %v", err)
+	} else if err := r.Register("conn", Labels{"id": "1"}, k1); err != nil {
+		t.Errorf("register failed, err is %v", err)
+	} else if err := r.Register("conn", Labels{"id": "1"}, NewKbps(nil, s1)); err == nil {
+		t.Errorf("should fail for duplicated")
+	} else if err := r.Register("other", nil, k1); err == nil {
+		t.Errorf("should fail for registered")
+	} else if r.Len() != 2 {
+		t.Errorf("invalid registry len %v", r.Len())
+	}
+
+	// Reset the first point for test.
+	base := time.Unix(100, 0)
+	for _, imp := range []*kxps{k0.sampler(), k1.sampler()} {
+		imp.points.size, imp.initialized = 0, false
+		imp.doSample(base)
+	}
+
+	// Each object is sampled by its tick in one pass.
+	s0.bytes, s1.bytes = 1000, 1000
+	r.sampleAll(base.Add(time.Second))
+	if v := k0.sampler().points.size; v != 2 {
+		t.Errorf("invalid points %v", v)
+	} else if v := k1.sampler().points.size; v != 1 {
+		t.Errorf("invalid points %v", v)
+	}
+
+	r.sampleAll(base.Add(2 * time.Second))
+	if v := k1.sampler().points.size; v != 2 {
+		t.Errorf("invalid points %v", v)
+	} else if v := k1.sampler().xps(base.Add(2*time.Second), 1000, 2*time.Second); v != 500 {
+		t.Errorf("invalid xps %v", v)
+	}
+
+	if err := r.Unregister("conn", Labels{"id": "0"}); err != nil {
+		t.Errorf("unregister failed, err is %v", err)
+	} else if err := r.Unregister("conn", Labels{"id": "0"}); err == nil {
+		t.Errorf("should fail for not registered")
+	} else if r.Len() != 1 {
+		t.Errorf("invalid registry len %v", r.Len())
+	}
+
+	// The unregistered object is not sampled, but the last rates are readable.
+	r.sampleAll(base.Add(3 * time.Second))
+	if v := k0.sampler().points.size; v != 3 {
+		t.Errorf("invalid points %v", v)
+	} else if v := k0.Average(); v <= 0 {
+		t.Errorf("invalid average %v", v)
+	} else if err := r.Register("conn", Labels{"id": "0"}, k0); err != nil {
+		t.Errorf("register again failed, err is %v", err)
+	} else if err := r.Unregister("conn", Labels{"id": "0"}); err != nil {
+		t.Errorf("unregister failed, err is %v", err)
+	}
+
+	// The closed object is removed.
+	if err := k1.Close(); err != nil {
+		t.Errorf("close failed, err is %v", err)
+	} else if r.Len() != 0 {
+		t.Errorf("invalid registry len %v", r.Len())
+	}
+}
+
+func TestRegistry_Start(t *testing.T) {
+	n := DefaultRegistry.Len()
+
+	k := NewKbps(nil, &mockKbpsSource{})
+	if err := k.Start(); err != nil {
+		t.Errorf("start failed, err is %v", err)
+	} else if v := DefaultRegistry.Len(); v != n+1 {
+		t.Errorf("invalid registry len %v", v)
+	}
+
+	if err := k.Close(); err != nil {
+		t.Errorf("close failed, err is %v", err)
+	} else if v := DefaultRegistry.Len(); v != n {
+		t.Errorf("invalid registry len %v", v)
+	}
+
+	if err := k.Start(); err != kxpsClosed {
+		t.Errorf("should fail for closed, err is %v", err)
+	}
+
+	// The DefaultRegistry use the RealClock.
+	c := NewFakeClock(time.Unix(0, 0))
+	k = NewKbpsClock(nil, &mockKbpsSource{}, c, time.Second, time.Second)
+	if err := k.Start(); err == nil {
+		t.Errorf("should fail for other clock")
+	} else if v := DefaultRegistry.Len(); v != n {
+		t.Errorf("invalid registry len %v", v)
+	} else if err := NewRegistryClock(nil, c, time.Second).Register("conn", nil, k); err != nil {
+		t.Errorf("register failed, err is %v", err)
+	}
+	k.Close()
+}
+
+func TestRegistry_WriteMetrics(t *testing.T) {
+	r := NewRegistry(nil, time.Second)
+
+	s := &mockKbpsSource{}
+	k := NewKbpsWindows(nil, s, time.Second, time.Second)
+	defer k.Close()
+
+	if err := r.Register("srs-stream", Labels{"app": "live", "stream": `a"b`}, k); err != nil {
+		t.Errorf("register failed, err is %v", err)
+	}
+	if err := r.Register("", nil, NewKbps(nil, s)); err != nil {
+		t.Errorf("register failed, err is %v", err)
+	}
+
+	s.bytes = 1000
+
+	for _, openMetrics := range []bool{false, true} {
+		var b bytes.Buffer
+		if err := r.WriteMetrics(&b, openMetrics); err != nil {
+			t.Errorf("write failed, err is %v", err)
+		}
+
+		out := b.String()
+		for _, line := range []string{
+			"# TYPE srs_stream_kbps gauge\n",
+			`srs_stream_kbps{app="live",stream="a\"b",window="1s"} `,
+			`srs_stream_kbps_ewma{app="live",stream="a\"b",window="1s"} `,
+			`srs_stream_kbps_average{app="live",stream="a\"b"} `,
+			`srs_stream_bytes_total{app="live",stream="a\"b"} 1000` + "\n",
+			"# TYPE go_goroutines gauge\n",
+			"# TYPE process_start_time_seconds gauge\n",
+		} {
+			if !strings.Contains(out, line) {
+				t.Errorf("no %v in %v", line, out)
+			}
+		}
+
+		if openMetrics != strings.HasSuffix(out, "# EOF\n") {
+			t.Errorf("invalid eof of %v", out)
+		} else if openMetrics != strings.Contains(out, "# TYPE srs_stream_bytes counter\n") {
+			t.Errorf("invalid counter of %v", out)
+		} else if openMetrics == strings.Contains(out, "# TYPE srs_stream_bytes_total counter\n") {
+			t.Errorf("invalid counter of %v", out)
+		}
+	}
+}
+
+func TestNewMetricsHandler(t *testing.T) {
+	h := NewMetricsHandler(NewRegistry(nil, time.Second))
+
+	for accept, ct := range map[string]string{
+		"":           MetricsPrometheus,
+		"text/plain": MetricsPrometheus,
+		"application/openmetrics-text; version=1.0.0": MetricsOpenMetrics,
+	} {
+		r := httptest.NewRequest("GET", "/metrics", nil)
+		r.Header.Set("Accept", accept)
+		w := httptest.NewRecorder()
+
+		h.ServeHTTP(w, r)
+		if v := w.Header().Get("Content-Type"); v != ct {
+			t.Errorf("invalid content type %v for %v", v, accept)
+		}
+	}
+}
+
+func TestFakeClock_Advance(t *testing.T) {
+	c := NewFakeClock(time.Unix(0, 0))
+
+	var ticks []int64
+	stop0 := c.Every(time.Second, func(now time.Time) {
+		ticks = append(ticks, now.Unix())
+	})
+	c.Every(3*time.Second, func(now time.Time) {
+		ticks = append(ticks, -now.Unix())
+	})
+
+	c.Advance(3 * time.Second)
+	if v := c.Now(); v.Unix() != 3 {
+		t.Errorf("invalid now %v", v)
+	} else if len(ticks) != 4 || ticks[0] != 1 || ticks[1] != 2 || ticks[2] != 3 || ticks[3] != -3 {
+		t.Errorf("invalid ticks %v", ticks)
+	}
+
+	stop0()
+	ticks = nil
+	c.Advance(3500 * time.Millisecond)
+	if v := c.Now(); v != time.Unix(6, 500*1000*1000) {
+		t.Errorf("invalid now %v", v)
+	} else if len(ticks) != 1 || ticks[0] != -6 {
+		t.Errorf("invalid ticks %v", ticks)
+	}
+}
+
+func TestRegistry_FakeClock(t *testing.T) {
+	c := NewFakeClock(time.Unix(100, 0))
+	r := NewRegistryClock(nil, c, time.Second)
+
+	s := &mockKbpsSource{}
+	k := NewKbpsClock(nil, s, c, time.Second, time.Second, 10*time.Second)
+	if err := r.Register("conn", nil, k); err != nil {
+		t.Errorf("register failed, err is %v", err)
+	}
+
+	// 1000B/s for 10s.
+	for i := 0; i < 10; i++ {
+		s.bytes += 1000
+		c.Advance(time.Second)
+	}
+
+	if v := k.Kbps(time.Second); v != 8 {
+		t.Errorf("invalid kbps %v", v)
+	} else if v := k.Kbps(10 * time.Second); v != 8 {
+		t.Errorf("invalid kbps %v", v)
+	} else if v := k.Average(); v != 8 {
+		t.Errorf("invalid average %v", v)
+	} else if v := k.KbpsEwma(10 * time.Second); v != 8 {
+		t.Errorf("invalid ewma %v", v)
+	}
+
+	// Manually tick, not sampled before tick elapsed.
+	r.Tick()
+	if v := k.sampler().points.size; v != 11 {
+		t.Errorf("invalid points %v", v)
+	}
+
+	// The cycle stops when no entry.
+	if err := k.Close(); err != nil {
+		t.Errorf("close failed, err is %v", err)
+	}
+	c.Advance(time.Second)
+	if len(c.timers) != 0 || r.stop != nil {
+		t.Errorf("cycle not stopped, timers=%v", len(c.timers))
+	}
+}
+
+func TestKbps_Snapshot(t *testing.T) {
+	c := NewFakeClock(time.Unix(0, 0))
+	r := NewRegistryClock(nil, c, time.Second)
+
+	s := &mockKbpsSource{}
+	k := NewKbpsClock(nil, s, c, time.Second, time.Second, 5*time.Second)
+	if err := r.Register("conn", nil, k); err != nil {
+		t.Errorf("register failed, err is %v", err)
+	}
+
+	for i := 0; i < 5; i++ {
+		s.bytes += 1000 * uint64(i+1)
+		c.Advance(time.Second)
+	}
+
+	v := k.Snapshot()
+	if v.At != time.Unix(5, 0) || v.Count != 15000 {
+		t.Errorf("invalid at=%v, count=%v", v.At, v.Count)
+	} else if v.Rates[time.Second] != 40 || v.Rates[5*time.Second] != 24 {
+		t.Errorf("invalid rates %v", v.Rates)
+	} else if v.Average != 24 || v.Ewmas[time.Second] != k.KbpsEwma(time.Second) {
+		t.Errorf("invalid average=%v, ewmas=%v", v.Average, v.Ewmas)
+	}
+
+	if err := k.Close(); err != nil {
+		t.Errorf("close failed, err is %v", err)
+	}
+
+	defer func() {
+		if r := recover(); r == nil {
+			t.Errorf("should panic for closed")
+		}
+	}()
+	k.Snapshot()
+}
+
+type atomicSource struct {
+	bytes    uint64
+	requests uint64
+}
+
+func (v *atomicSource) TotalBytes() uint64 {
+	return atomic.LoadUint64(&v.bytes)
+}
+
+func (v *atomicSource) NbRequests() uint64 {
+	return atomic.LoadUint64(&v.requests)
+}
+
+// Run with -race to check the getters while sampling.
+func TestKxps_Race(t *testing.T) {
+	r := NewRegistry(nil, time.Millisecond)
+
+	s := &atomicSource{}
+	kbps := NewKbpsWindows(nil, s, time.Millisecond, time.Millisecond, 10*time.Millisecond)
+	krps := NewKrpsWindows(nil, s, time.Millisecond, time.Millisecond, 10*time.Millisecond)
+	h := NewHistogramWindows(nil, time.Millisecond, 10*time.Millisecond)
+
+	if err := r.Register("kbps", nil, kbps); err != nil {
+		t.Errorf("register failed, err is %v", err)
+	} else if err := r.Register("krps", nil, krps); err != nil {
+		t.Errorf("register failed, err is %v", err)
+	} else if err := r.Register("latency", nil, h); err != nil {
+		t.Errorf("register failed, err is %v", err)
+	}
+
+	done := make(chan bool)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+
+				atomic.AddUint64(&s.bytes, 100)
+				atomic.AddUint64(&s.requests, 1)
+				h.Observe(1)
+
+				_ = kbps.Kbps(time.Millisecond)
+				_ = kbps.KbpsEwma(10 * time.Millisecond)
+				_ = kbps.Average()
+				_ = kbps.Snapshot()
+				_ = krps.Rps(time.Millisecond)
+				_ = krps.Snapshot()
+				_ = h.P99(10 * time.Millisecond)
+				_ = r.WriteMetrics(ioutil.Discard, false)
+			}
+		}()
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	close(done)
+	wg.Wait()
+
+	for _, c := range []io.Closer{kbps, krps, h} {
+		if err := c.Close(); err != nil {
+			t.Errorf("close failed, err is %v", err)
+		}
+	}
+}
+
+func TestNewStatsHandler(t *testing.T) {
+	c := NewFakeClock(time.Unix(0, 0))
+	r := NewRegistryClock(nil, c, time.Second)
+
+	s := &mockKbpsSource{}
+	k := NewKbpsClock(nil, s, c, time.Second, time.Second)
+	h := NewHistogramClock(nil, c, time.Second, time.Second)
+	defer k.Close()
+	defer h.Close()
+
+	if err := r.Register("conn", Labels{"id": "0"}, k); err != nil {
+		t.Errorf("register failed, err is %v", err)
+	} else if err := r.Register("latency", nil, h); err != nil {
+		t.Errorf("register failed, err is %v", err)
+	}
+
+	s.bytes = 1000
+	h.Observe(10)
+	c.Advance(time.Second)
+
+	w := httptest.NewRecorder()
+	NewStatsHandler(r).ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/stats", nil))
+
+	var res struct {
+		Code int     `json:"code"`
+		Data []*Stat `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Errorf("unmarshal %v failed, err is %v", w.Body.String(), err)
+	} else if res.Code != 0 || len(res.Data) != 2 {
+		t.Errorf("invalid response %v", w.Body.String())
+	} else if v := res.Data[0]; v.Name != "conn" || v.Labels["id"] != "0" || v.Type != "kbps" || v.Count != 1000 || v.Rates["1s"] != 8 {
+		t.Errorf("invalid stat %+v", v)
+	} else if v := res.Data[1]; v.Name != "latency" || v.Type != "histogram" || v.Count != 1 || math.Abs(v.Quantiles["1s"]["p99"]-10) > 0.1 {
+		t.Errorf("invalid stat %+v", v)
+	}
+
+	// The jsonp.
+	w = httptest.NewRecorder()
+	NewStatsHandler(r).ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/stats?callback=fn", nil))
+	if v := w.Body.String(); !strings.HasPrefix(v, "fn({") || !strings.HasSuffix(v, "})") {
+		t.Errorf("invalid jsonp %v", v)
+	}
+}
@@ -0,0 +1,114 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2013-2016 Oryx(ossrs)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// The json stats api for kxps.
+package kxps
+
+import (
+	oh "github.com/ossrs/go-oryx-lib/http"
+	"net/http"
+)
+
+// The stat of registered object, for the json api.
+type Stat struct {
+	// The registered name and labels.
+	Name   string `json:"name"`
+	Labels Labels `json:"labels,omitempty"`
+	// The type of object, kbps, krps or histogram.
+	Type string `json:"type"`
+	// The total count, the bytes for kbps, the requests for krps, the observed values for histogram.
+	Count uint64 `json:"count"`
+	// The average rate, kbps for kbps, rps for krps and histogram.
+	Average float64 `json:"average"`
+	// The trailing and ewma rate of window, key is the window, for example, 10s.
+	Rates map[string]float64 `json:"rates"`
+	Ewmas map[string]float64 `json:"ewmas"`
+	// For histogram, the p50, p90, p99 and max of window.
+	Quantiles map[string]map[string]float64 `json:"quantiles,omitempty"`
+}
+
+// Get the stats of all objects registered with name, sorted by name and labels.
+func (v *Registry) Stats() []*Stat {
+	stats := []*Stat{}
+
+	for _, e := range v.named() {
+		// For kbps, the bytes per seconds to kbps.
+		typ, scale := "krps", float64(1)
+		if _, ok := e.sampler.(Kbps); ok {
+			typ, scale = "kbps", float64(8)/1000
+		}
+
+		h, isHistogram := e.sampler.(*histogram)
+		if isHistogram {
+			typ = "histogram"
+		}
+
+		// all windows at the same time, ignore when closed.
+		snapshot, ok := e.sampler.sampler().snapshot(scale)
+		if !ok {
+			continue
+		}
+
+		stat := &Stat{
+			Name: e.name, Labels: e.labels, Type: typ,
+			Count: snapshot.Count, Average: snapshot.Average,
+			Rates: make(map[string]float64), Ewmas: make(map[string]float64),
+		}
+		for window, rate := range snapshot.Rates {
+			stat.Rates[window.String()] = rate
+		}
+		for window, rate := range snapshot.Ewmas {
+			stat.Ewmas[window.String()] = rate
+		}
+
+		if isHistogram {
+			stat.Quantiles = make(map[string]map[string]float64)
+			for _, window := range h.Windows() {
+				stat.Quantiles[window.String()] = map[string]float64{
+					"p50": h.quantile(snapshot.At, window, 0.5),
+					"p90": h.quantile(snapshot.At, window, 0.9),
+					"p99": h.quantile(snapshot.At, window, 0.99),
+					"max": h.quantile(snapshot.At, window, 1),
+				}
+			}
+		}
+
+		stats = append(stats, stat)
+	}
+
+	return stats
+}
+
+// The http handler to response the stats of registry in the standard {code, server, data},
+// where data is the list of Stat, and support jsonp by the callback query, for example:
+//
+//	http.Handle("/api/v1/stats", kxps.NewStatsHandler(nil))
+//
+// @remark use the DefaultRegistry when r is nil.
+func NewStatsHandler(r *Registry) http.Handler {
+	if r == nil {
+		r = DefaultRegistry
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		oh.WriteData(r.ctx, w, req, r.Stats())
+	})
+}
@@ -0,0 +1,220 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2013-2016 Oryx(ossrs)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package logger
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// The policy when the queue of AsyncWriter is full.
+type OverflowPolicy int
+
+const (
+	// Block the writer until the queue has space.
+	OverflowBlock OverflowPolicy = iota
+	// Drop the oldest log in queue.
+	OverflowDropOldest
+	// Drop the log to write.
+	OverflowDropNewest
+)
+
+func (v OverflowPolicy) String() string {
+	switch v {
+	case OverflowBlock:
+		return "block"
+	case OverflowDropOldest:
+		return "drop-oldest"
+	case OverflowDropNewest:
+		return "drop-newest"
+	default:
+		return fmt.Sprintf("OverflowPolicy(%d)", int(v))
+	}
+}
+
+// The max number of logs to write in a batch.
+const asyncMaxBatch = 256
+
+var asyncWriterClosed = fmt.Errorf("async writer closed")
+
+// The writer which queue the logs and write in batch by a goroutine,
+// so the slow io never block the logging goroutines, for example:
+//
+//	logger.Switch(logger.NewAsyncWriter(f, 4096, logger.OverflowDropOldest))
+//
+// @remark the logs in queue are flushed when close.
+type AsyncWriter struct {
+	w        io.Writer
+	capacity int
+	policy   OverflowPolicy
+
+	lock *sync.Mutex
+	// signal when queue changed or closed.
+	cond  *sync.Cond
+	queue [][]byte
+	// the number of logs enqueued, and the number of logs written or dropped from queue.
+	enqueued  uint64
+	processed uint64
+	dropped   uint64
+	// the error of last write, reset when flush.
+	err    error
+	closed bool
+	done   chan bool
+}
+
+// Create the async writer for w, which queue at most capacity logs.
+func NewAsyncWriter(w io.Writer, capacity int, policy OverflowPolicy) *AsyncWriter {
+	if capacity <= 0 {
+		capacity = 1
+	}
+
+	v := &AsyncWriter{
+		w:        w,
+		capacity: capacity,
+		policy:   policy,
+		lock:     &sync.Mutex{},
+		done:     make(chan bool),
+	}
+	v.cond = sync.NewCond(v.lock)
+
+	go v.cycle()
+	return v
+}
+
+// Interface io.Writer, queue the log, the p is copied.
+func (v *AsyncWriter) Write(p []byte) (n int, err error) {
+	b := append([]byte(nil), p...)
+
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	for !v.closed && len(v.queue) >= v.capacity {
+		switch v.policy {
+		case OverflowDropNewest:
+			v.dropped++
+			return len(p), nil
+		case OverflowDropOldest:
+			v.queue = v.queue[1:]
+			v.processed++
+			v.dropped++
+		default:
+			v.cond.Wait()
+		}
+	}
+
+	if v.closed {
+		return 0, asyncWriterClosed
+	}
+
+	v.queue = append(v.queue, b)
+	v.enqueued++
+	v.cond.Broadcast()
+
+	return len(p), nil
+}
+
+// Get the number of dropped logs when overflow.
+func (v *AsyncWriter) Dropped() uint64 {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	return v.dropped
+}
+
+// Wait for the queued logs to be written,
+// @return the error of last write since previous flush.
+func (v *AsyncWriter) Flush() (err error) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	for target := v.enqueued; v.processed < target; {
+		v.cond.Wait()
+	}
+
+	err, v.err = v.err, nil
+	return
+}
+
+// Interface io.Closer, flush the logs then close the underlayer io.
+func (v *AsyncWriter) Close() (err error) {
+	err = v.Flush()
+
+	v.lock.Lock()
+	if v.closed {
+		v.lock.Unlock()
+		return
+	}
+	v.closed = true
+	v.cond.Broadcast()
+	v.lock.Unlock()
+
+	<-v.done
+
+	if w, ok := v.w.(io.Closer); ok {
+		if cerr := w.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return
+}
+
+// Write the queued logs in batch.
+func (v *AsyncWriter) cycle() {
+	defer close(v.done)
+
+	var b bytes.Buffer
+	for {
+		v.lock.Lock()
+		for len(v.queue) == 0 && !v.closed {
+			v.cond.Wait()
+		}
+		if len(v.queue) == 0 {
+			v.lock.Unlock()
+			return
+		}
+
+		batch := v.queue
+		if len(batch) > asyncMaxBatch {
+			batch = batch[:asyncMaxBatch]
+		}
+		v.queue = v.queue[len(batch):]
+		// the writer may wait for space.
+		v.cond.Broadcast()
+		v.lock.Unlock()
+
+		b.Reset()
+		for _, p := range batch {
+			b.Write(p)
+		}
+		_, err := v.w.Write(b.Bytes())
+
+		v.lock.Lock()
+		v.processed += uint64(len(batch))
+		if err != nil {
+			v.err = err
+		}
+		v.cond.Broadcast()
+		v.lock.Unlock()
+	}
+}
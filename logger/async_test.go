@@ -0,0 +1,164 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2013-2016 Oryx(ossrs)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package logger
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// The writer which block until the gate is open.
+type gateWriter struct {
+	gate   chan bool
+	lock   sync.Mutex
+	b      bytes.Buffer
+	writes int
+	closed bool
+}
+
+func (v *gateWriter) Write(p []byte) (int, error) {
+	<-v.gate
+
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	v.writes++
+	return v.b.Write(p)
+}
+
+func (v *gateWriter) Close() error {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	v.closed = true
+	return nil
+}
+
+func (v *gateWriter) String() string {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	return v.b.String()
+}
+
+func TestAsyncWriter_Batch(t *testing.T) {
+	w := &gateWriter{gate: make(chan bool)}
+	close(w.gate)
+
+	aw := NewAsyncWriter(w, 100, OverflowBlock)
+	for i := 0; i < 10; i++ {
+		fmt.Fprintf(aw, "line%v\n", i)
+	}
+
+	if err := aw.Flush(); err != nil {
+		t.Errorf("flush failed, err is %v", err)
+	} else if v := w.String(); strings.Count(v, "\n") != 10 || !strings.HasPrefix(v, "line0\n") || !strings.HasSuffix(v, "line9\n") {
+		t.Errorf("invalid log %v", v)
+	}
+
+	if err := aw.Close(); err != nil {
+		t.Errorf("close failed, err is %v", err)
+	} else if !w.closed {
+		t.Errorf("should close the underlayer io")
+	} else if _, err := aw.Write([]byte("line")); err == nil {
+		t.Errorf("should fail for closed")
+	}
+}
+
+func TestAsyncWriter_Overflow(t *testing.T) {
+	for _, c := range []struct {
+		policy OverflowPolicy
+		lines  string
+	}{
+		{OverflowDropNewest, "line0\nline1\nline2\n"},
+		{OverflowDropOldest, "line0\nline3\nline4\n"},
+	} {
+		w := &gateWriter{gate: make(chan bool)}
+		aw := NewAsyncWriter(w, 2, c.policy)
+
+		// The line0 is dequeued and blocked in writing.
+		fmt.Fprint(aw, "line0\n")
+		for {
+			aw.lock.Lock()
+			n := len(aw.queue)
+			aw.lock.Unlock()
+			if n == 0 {
+				break
+			}
+		}
+
+		for i := 1; i < 5; i++ {
+			fmt.Fprintf(aw, "line%v\n", i)
+		}
+		close(w.gate)
+
+		if err := aw.Close(); err != nil {
+			t.Errorf("close failed, err is %v", err)
+		} else if v := aw.Dropped(); v != 2 {
+			t.Errorf("%v invalid dropped %v", c.policy, v)
+		} else if v := w.String(); v != c.lines {
+			t.Errorf("%v invalid log %v", c.policy, v)
+		}
+	}
+}
+
+func TestAsyncWriter_Block(t *testing.T) {
+	w := &gateWriter{gate: make(chan bool)}
+	aw := NewAsyncWriter(w, 1, OverflowBlock)
+
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		for i := 0; i < 10; i++ {
+			fmt.Fprintf(aw, "line%v\n", i)
+		}
+	}()
+
+	close(w.gate)
+	<-done
+
+	if err := aw.Close(); err != nil {
+		t.Errorf("close failed, err is %v", err)
+	} else if v := aw.Dropped(); v != 0 {
+		t.Errorf("invalid dropped %v", v)
+	} else if v := w.String(); strings.Count(v, "\n") != 10 {
+		t.Errorf("invalid log %v", v)
+	}
+}
+
+func TestAsyncWriter_Switch(t *testing.T) {
+	w := &gateWriter{gate: make(chan bool)}
+	close(w.gate)
+
+	Switch(NewAsyncWriter(w, 100, OverflowBlock))
+	T(nil, "trace log")
+
+	// The logs are flushed when close.
+	if err := Close(); err != nil {
+		t.Errorf("close failed, err is %v", err)
+	} else if v := w.String(); !strings.Contains(v, "trace log") || !w.closed {
+		t.Errorf("invalid log %v", v)
+	}
+}
@@ -0,0 +1,139 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2013-2016 Oryx(ossrs)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package logger
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// Whether log the caller, the file:line and goroutine of code which call the logger, for example:
+//
+//	[trace] 2017/01/01 10:00:00.000000 [100][7][publish.go:32][g18] publish stream
+var caller bool
+
+// Whether attach the stack of caller to the error log.
+var errorStack bool
+
+// Get whether log the caller.
+func GetCaller() bool {
+	lock.Lock()
+	defer lock.Unlock()
+
+	return caller
+}
+
+// Set whether log the file:line and goroutine of caller, default to false.
+// @remark it's slower to log with caller, for the stack is walked for each log.
+func SetCaller(enabled bool) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	caller = enabled
+	apply()
+}
+
+// Get whether attach the stack to the error log.
+func GetErrorStack() bool {
+	lock.Lock()
+	defer lock.Unlock()
+
+	return errorStack
+}
+
+// Set whether attach the stack of caller to the error log, default to false.
+func SetErrorStack(enabled bool) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	errorStack = enabled
+	apply()
+}
+
+// Get the stack of current goroutine, including the frames of logger,
+// which are skipped when lookup the caller.
+func callers() []uintptr {
+	var pcs [32]uintptr
+	return pcs[:runtime.Callers(3, pcs[:])]
+}
+
+// Get the first frame which is not in logger package.
+func callerFrame(pcs []uintptr) (frame runtime.Frame, ok bool) {
+	frames := runtime.CallersFrames(pcs)
+	for {
+		f, more := frames.Next()
+		if f.PC != 0 && !isLoggerFrame(f) {
+			return f, true
+		}
+		if !more {
+			return
+		}
+	}
+}
+
+// Format the frames which are not in logger package, like the stack of panic:
+//
+//	main.publish(...)
+//		/path/to/publish.go:32
+func callerStack(pcs []uintptr) string {
+	var b bytes.Buffer
+
+	frames := runtime.CallersFrames(pcs)
+	for {
+		f, more := frames.Next()
+		if f.PC != 0 && !isLoggerFrame(f) {
+			if b.Len() > 0 {
+				b.WriteString("\n")
+			}
+			fmt.Fprintf(&b, "%v(...)\n\t%v:%v", f.Function, f.File, f.Line)
+		}
+		if !more {
+			return b.String()
+		}
+	}
+}
+
+// The file:line of frame, without the directory of file.
+func shortFileLine(f runtime.Frame) string {
+	return fmt.Sprintf("%v:%v", filepath.Base(f.File), f.Line)
+}
+
+// Get the id of current goroutine, parse from the first line of stack:
+//
+//	goroutine 18 [running]:
+//
+// @return 0 if failed.
+func goroutineID() int {
+	var b [64]byte
+	s := strings.TrimPrefix(string(b[:runtime.Stack(b[:], false)]), "goroutine ")
+
+	if i := strings.IndexByte(s, ' '); i > 0 {
+		if id, err := strconv.Atoi(s[:i]); err == nil {
+			return id
+		}
+	}
+	return 0
+}
@@ -0,0 +1,201 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2013-2016 Oryx(ossrs)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package logger
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+)
+
+// The context with trace id, parent id and fields, rendered in every log line,
+// to correlate the logs of a session or request across services.
+type TraceContext interface {
+	Context
+	// Get the trace id, shared by all contexts of a request across services.
+	TraceID() string
+	// Get the parent id, the id of context which create this one, empty for root.
+	ParentID() string
+	// Get the fields, for example, the stream url or client ip.
+	Fields() Fields
+}
+
+// The field keys of trace context.
+const (
+	FieldTraceID  = "trace_id"
+	FieldParentID = "parent_id"
+)
+
+// The last generated cid.
+var lastCid int64 = 100
+
+// The implementation object.
+type traceContext struct {
+	cid      int
+	traceID  string
+	parentID string
+	fields   Fields
+}
+
+// Create the root context with trace id and fields, the cid is generated.
+// @param traceID the trace id from upstream, for example, the header X-Request-Id,
+// or empty to generate one.
+func NewTraceContext(traceID, parentID string, fields Fields) TraceContext {
+	if traceID == "" {
+		traceID = NewTraceID()
+	}
+
+	return &traceContext{
+		cid:      int(atomic.AddInt64(&lastCid, 1)),
+		traceID:  traceID,
+		parentID: parentID,
+		fields:   copyFields(nil, fields),
+	}
+}
+
+// Create the child context of parent, with the same trace id and more fields,
+// the parent id is the cid of parent, for example, the http request of a connection.
+// @remark create a root context when parent is nil.
+func NewChildContext(parent Context, fields Fields) TraceContext {
+	if parent == nil {
+		return NewTraceContext("", "", fields)
+	}
+
+	traceID, _, parentFields := contextFields(parent)
+	if traceID == "" {
+		traceID = NewTraceID()
+	}
+
+	return &traceContext{
+		cid:      int(atomic.AddInt64(&lastCid, 1)),
+		traceID:  traceID,
+		parentID: strconv.Itoa(parent.Cid()),
+		fields:   copyFields(parentFields, fields),
+	}
+}
+
+func (v *traceContext) Cid() int {
+	return v.cid
+}
+
+func (v *traceContext) TraceID() string {
+	return v.traceID
+}
+
+func (v *traceContext) ParentID() string {
+	return v.parentID
+}
+
+func (v *traceContext) Fields() Fields {
+	return v.fields
+}
+
+func (v *traceContext) String() string {
+	return fmt.Sprintf("cid=%v, trace=%v, parent=%v, fields=%v", v.cid, v.traceID, v.parentID, v.fields)
+}
+
+// Generate a random trace id, 16 bytes in hex.
+func NewTraceID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(atomic.AddInt64(&lastCid, 1), 16)
+	}
+	return hex.EncodeToString(b)
+}
+
+// Merge the fields to a new one, the latter override the former.
+func copyFields(a ...Fields) Fields {
+	var n int
+	for _, f := range a {
+		n += len(f)
+	}
+	if n == 0 {
+		return nil
+	}
+
+	v := make(Fields, n)
+	for _, f := range a {
+		for k, value := range f {
+			v[k] = value
+		}
+	}
+	return v
+}
+
+// Get the trace id, parent id and fields of ctx, empty when not TraceContext.
+func contextFields(ctx Context) (traceID, parentID string, fields Fields) {
+	if v, ok := ctx.(TraceContext); ok {
+		return v.TraceID(), v.ParentID(), v.Fields()
+	}
+	return
+}
+
+// Extract the fields from args, merged with the trace id, parent id and fields of ctx,
+// @return the args without fields and the merged fields, nil if no field.
+func logFields(ctx Context, a []interface{}) ([]interface{}, Fields) {
+	args, fields := extractFields(a)
+
+	traceID, parentID, ctxFields := contextFields(ctx)
+	if traceID == "" && parentID == "" && len(ctxFields) == 0 {
+		return args, fields
+	}
+
+	merged := copyFields(ctxFields, fields)
+	if merged == nil {
+		merged = make(Fields)
+	}
+
+	// the trace id and parent id override the fields, which are moved aside as fields.key.
+	for k, id := range map[string]string{FieldTraceID: traceID, FieldParentID: parentID} {
+		if id == "" {
+			continue
+		}
+		if value, ok := merged[k]; ok {
+			merged[merged.aside(k)] = value
+		}
+		merged[k] = id
+	}
+
+	return args, merged
+}
+
+// The key of logger context in context.Context.
+type contextKey struct{}
+
+// Attach the logger context to ctx, for example, the context of http request.
+func WithContext(ctx context.Context, v Context) context.Context {
+	return context.WithValue(ctx, contextKey{}, v)
+}
+
+// Get the logger context from ctx, nil if not attached.
+func FromContext(ctx context.Context) Context {
+	if ctx == nil {
+		return nil
+	}
+	if v, ok := ctx.Value(contextKey{}).(Context); ok {
+		return v
+	}
+	return nil
+}
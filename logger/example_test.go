@@ -23,7 +23,10 @@ package logger_test
 
 import (
 	ol "github.com/ossrs/go-oryx-lib/logger"
+	"net/http"
 	"os"
+	"syscall"
+	"time"
 )
 
 func ExampleLogger() {
@@ -69,3 +72,123 @@ func ExampleLogger_ConnectionBased() {
 	ol.Warn.Println(ctx, "The log text.")
 	ol.Error.Println(ctx, "The log text.")
 }
+
+func ExampleSetLevel() {
+	// Parse the level from config, for example, info.
+	if level, err := ol.ParseLevel("info"); err == nil {
+		ol.SetLevel(level)
+	}
+
+	// The verbose log is enabled.
+	ol.I(nil, "The log text.")
+
+	// Change the level at runtime by http api:
+	//	curl -X POST http://127.0.0.1:1985/api/v1/log/level?level=trace
+	http.Handle("/api/v1/log/level", ol.NewLevelHandler(nil))
+
+	// Or toggle the verbose log by signal:
+	//	killall -USR2 server
+	stop := ol.ToggleLevelOnSignal(nil, syscall.SIGUSR2)
+	defer stop()
+}
+
+func ExampleSetFormat() {
+	// Write one json object per line, for log pipeline to parse.
+	ol.SetFormat(ol.FormatJson)
+
+	// The fields are keys of json object, or k=v at the end of text line.
+	ctx := context(100)
+	ol.T(ctx, "The log text.", ol.Fields{"stream": "livestream", "size": 1024})
+}
+
+func ExampleNewTraceContext() {
+	http.HandleFunc("/api/v1/streams", func(w http.ResponseWriter, r *http.Request) {
+		// Use the request id from upstream, to correlate the logs across services.
+		ctx := ol.NewTraceContext(r.Header.Get("X-Request-Id"), "", ol.Fields{"ip": r.RemoteAddr})
+		ol.T(ctx, "The log text.")
+
+		// The child context has the same trace id, with more fields.
+		child := ol.NewChildContext(ctx, ol.Fields{"stream": "livestream"})
+		ol.T(child, "The log text.")
+
+		// Pass the context by context.Context.
+		r = r.WithContext(ol.WithContext(r.Context(), child))
+		ol.T(ol.FromContext(r.Context()), "The log text.")
+	})
+}
+
+func ExampleRotatingFile() {
+	// Rotate every 100MB or 24h, keep 10 gzipped backups.
+	f := ol.NewRotatingFile("sys.log")
+	f.MaxSize, f.Interval = 100*1024*1024, 24*time.Hour
+	f.MaxBackups, f.Compress = 10, true
+
+	// Never drop lines, even rotate or reopen.
+	ol.Switch(f)
+	defer ol.Close()
+
+	// Reopen when logrotate moved the file and send SIGUSR1.
+	stop := f.ReopenOnSignal(syscall.SIGUSR1)
+	defer stop()
+
+	ol.T(nil, "The log text.")
+}
+
+func ExampleNewAsyncWriter() {
+	f := ol.NewRotatingFile("sys.log")
+
+	// Write logs by a goroutine, never block when disk stall, drop the oldest logs when queue full.
+	w := ol.NewAsyncWriter(f, 4096, ol.OverflowDropOldest)
+	ol.Switch(w)
+
+	// Flush the logs and close the file.
+	defer ol.Close()
+
+	ol.T(nil, "The log text.")
+	_ = w.Dropped()
+}
+
+func ExampleSwitchSink() {
+	// Under systemd, write to journald, query by: journalctl -t srs -p err
+	if s, err := ol.NewJournaldSink("", "srs"); err == nil {
+		ol.SwitchSink(s)
+		defer ol.Close()
+	}
+
+	// Or write to local syslog, or remote syslog by udp/tcp.
+	if s, err := ol.NewSyslogSink("", "", "srs"); err == nil {
+		ol.SwitchSink(s)
+		defer ol.Close()
+	}
+
+	ol.E(context(100), "The log text.", ol.Fields{"stream": "livestream"})
+}
+
+func ExampleNewRouter() {
+	errorFile := ol.NewRotatingFile("error.log")
+	debugFile := ol.NewRotatingFile("debug.log")
+
+	// The errors to error.log, the info of rtmp module to debug.log,
+	// and the trace and warn to stdout, at most 10 logs per second for each call site.
+	ol.SetLevel(ol.LevelInfo)
+	ol.SwitchSink(ol.NewRouter(
+		&ol.Route{Levels: []ol.Level{ol.LevelError}, Writer: errorFile},
+		&ol.Route{Levels: []ol.Level{ol.LevelInfo}, Modules: []string{"rtmp"}, Writer: debugFile},
+		&ol.Route{Levels: []ol.Level{ol.LevelTrace, ol.LevelWarn}, Writer: os.Stdout, RateLimit: 10},
+	))
+	defer ol.Close()
+
+	ctx := ol.NewTraceContext("", "", ol.Fields{ol.FieldModule: "rtmp"})
+	ol.I(ctx, "The log text.")
+}
+
+func ExampleSetCaller() {
+	// Log the file:line and goroutine of caller, for example:
+	//	[trace] 2017/01/01 10:00:00.000000 [100][7][main.go:32][g18] The log text.
+	ol.SetCaller(true)
+	// Attach the stack to the error log.
+	ol.SetErrorStack(true)
+
+	ol.T(context(100), "The log text.")
+	ol.E(context(100), "The error text.")
+}
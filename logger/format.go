@@ -0,0 +1,239 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2013-2016 Oryx(ossrs)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The format of log.
+type Format int
+
+const (
+	// The text line, for example:
+	//	[trace] 2017/01/01 10:00:00.000000 [100][7] publish stream stream=livestream
+	FormatText Format = iota
+	// The json object per line, for example:
+	//	{"time":"2017-01-01T10:00:00.000000+08:00","level":"trace","pid":100,"cid":7,"msg":"publish stream","stream":"livestream"}
+	FormatJson
+)
+
+func (v Format) String() string {
+	switch v {
+	case FormatText:
+		return "text"
+	case FormatJson:
+		return "json"
+	default:
+		return fmt.Sprintf("Format(%d)", int(v))
+	}
+}
+
+// The current format, default to text.
+var format = FormatText
+
+// Get the current format.
+func GetFormat() Format {
+	lock.Lock()
+	defer lock.Unlock()
+
+	return format
+}
+
+// Set the format of log, for example, FormatJson for log pipeline to parse.
+func SetFormat(f Format) {
+	if f != FormatText && f != FormatJson {
+		return
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	format = f
+	apply()
+}
+
+// The key-value fields of log, which can be one of the args of log, for example:
+//
+//	logger.T(ctx, "publish stream", logger.Fields{"stream": "livestream"})
+//
+// In text format, the fields are rendered as k=v sorted by key at the end of line,
+// while in json format, each field is a key of the object.
+type Fields map[string]interface{}
+
+// The keys of json log, the field with the same key is renamed to fields.key,
+// or fields.fields.key when fields.key is also used, see Fields.aside.
+var reservedKeys = map[string]bool{"time": true, "level": true, "pid": true, "cid": true, "msg": true}
+
+// The keys of caller and stack, reserved when enabled.
+var callerKeys = map[string]bool{"caller": true, "goroutine": true}
+var stackKeys = map[string]bool{"stack": true}
+
+// Extract the fields from args, @return the args without fields and the merged fields.
+func extractFields(a []interface{}) ([]interface{}, Fields) {
+	var fields Fields
+	var args []interface{}
+
+	for i, arg := range a {
+		f, ok := arg.(Fields)
+		if !ok {
+			if args != nil {
+				args = append(args, arg)
+			}
+			continue
+		}
+
+		// copy the args before the first fields.
+		if args == nil {
+			args = append(make([]interface{}, 0, len(a)), a[:i]...)
+		}
+		if fields == nil {
+			fields = make(Fields)
+		}
+		for k, v := range f {
+			fields[k] = v
+		}
+	}
+
+	if fields == nil {
+		return a, nil
+	}
+	return args, fields
+}
+
+// Get the sorted keys of fields.
+func (v Fields) keys() []string {
+	keys := make([]string, 0, len(v))
+	for k := range v {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Get the key to move the field k aside, prefixed by fields. until not used by other fields.
+func (v Fields) aside(k string) string {
+	key := "fields." + k
+	for _, ok := v[key]; ok; _, ok = v[key] {
+		key = "fields." + key
+	}
+	return key
+}
+
+// Format the fields as k=v sorted by key.
+func (v Fields) String() string {
+	pairs := make([]string, 0, len(v))
+	for _, k := range v.keys() {
+		pairs = append(pairs, fmt.Sprintf("%v=%v", k, v[k]))
+	}
+	return strings.Join(pairs, " ")
+}
+
+// The logger to write json object per line.
+type loggerJson struct {
+	lock  *sync.Mutex
+	w     io.Writer
+	level Level
+	// whether log the caller and stack.
+	caller bool
+	stack  bool
+}
+
+func newLoggerJson(w io.Writer, level Level, caller, stack bool) Logger {
+	return &loggerJson{lock: &sync.Mutex{}, w: w, level: level, caller: caller, stack: stack}
+}
+
+// Whether the key is used by the json log.
+func (v *loggerJson) reserved(k string) bool {
+	return reservedKeys[k] || (v.caller && callerKeys[k]) || (v.stack && stackKeys[k])
+}
+
+func (v *loggerJson) Println(ctx Context, a ...interface{}) {
+	args, fields := logFields(ctx, a)
+
+	var b bytes.Buffer
+	b.WriteString(`{"time":`)
+	writeJson(&b, time.Now().Format("2006-01-02T15:04:05.000000Z07:00"))
+	b.WriteString(`,"level":`)
+	writeJson(&b, v.level.String())
+	fmt.Fprintf(&b, `,"pid":%v`, os.Getpid())
+	if ctx != nil {
+		fmt.Fprintf(&b, `,"cid":%v`, ctx.Cid())
+	}
+	b.WriteString(`,"msg":`)
+	writeJson(&b, strings.TrimSuffix(fmt.Sprintln(args...), "\n"))
+
+	var pcs []uintptr
+	if v.caller || v.stack {
+		pcs = callers()
+	}
+	if v.caller {
+		if f, ok := callerFrame(pcs); ok {
+			b.WriteString(`,"caller":`)
+			writeJson(&b, shortFileLine(f))
+		}
+		fmt.Fprintf(&b, `,"goroutine":%v`, goroutineID())
+	}
+	if v.stack {
+		b.WriteString(`,"stack":`)
+		writeJson(&b, callerStack(pcs))
+	}
+
+	for _, k := range fields.keys() {
+		key := k
+		if v.reserved(k) {
+			key = fields.aside(k)
+		}
+
+		b.WriteString(",")
+		writeJson(&b, key)
+		b.WriteString(":")
+		writeJson(&b, fields[k])
+	}
+	b.WriteString("}\n")
+
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	v.w.Write(b.Bytes())
+}
+
+// Write the json of value, use the string of value when failed.
+func writeJson(b *bytes.Buffer, v interface{}) {
+	if err, ok := v.(error); ok {
+		v = err.Error()
+	}
+
+	s, err := json.Marshal(v)
+	if err != nil {
+		s, _ = json.Marshal(fmt.Sprint(v))
+	}
+	b.Write(s)
+}
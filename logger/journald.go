@@ -0,0 +1,171 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2013-2016 Oryx(ossrs)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package logger
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// The native socket of systemd-journald.
+const JournaldSocket = "/run/systemd/journal/socket"
+
+// The sink to send log to systemd-journald by the native protocol,
+// the level is mapped to PRIORITY, the cid and fields are the journal fields,
+// and the caller is CODE_FILE, CODE_LINE and CODE_FUNC,
+// for example, query the error logs of cid 7 by:
+//
+//	journalctl -p err CID=7
+//
+// @see https://systemd.io/JOURNAL_NATIVE_PROTOCOL/
+type JournaldSink struct {
+	// The SYSLOG_IDENTIFIER, default to the name of program.
+	Tag string
+
+	lock *sync.Mutex
+	conn *net.UnixConn
+}
+
+// Create the journald sink, use the JournaldSocket when path is empty.
+func NewJournaldSink(path, tag string) (*JournaldSink, error) {
+	if path == "" {
+		path = JournaldSocket
+	}
+	if tag == "" {
+		tag = filepath.Base(os.Args[0])
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+
+	v := &JournaldSink{
+		Tag:  tag,
+		lock: &sync.Mutex{},
+		conn: conn,
+	}
+	return v, nil
+}
+
+// The journal fields written by sink, the field with the same name is renamed to FIELDS_NAME.
+var journaldReservedNames = map[string]bool{
+	"MESSAGE": true, "PRIORITY": true, "SYSLOG_IDENTIFIER": true, "SYSLOG_PID": true, "CID": true,
+	"CODE_FILE": true, "CODE_LINE": true, "CODE_FUNC": true,
+}
+
+// The max length of journal field name.
+const journaldMaxName = 64
+
+// Convert the key to journal field name, uppercase letters, digits and underscore,
+// prefixed by FIELDS_ when it's reserved or not starting with letter,
+// for the underscore is trusted field.
+func journaldName(key string) string {
+	b := []byte(strings.ToUpper(key))
+	for i, c := range b {
+		if !(c >= 'A' && c <= 'Z') && !(c >= '0' && c <= '9') {
+			b[i] = '_'
+		}
+	}
+
+	name := string(b)
+	if len(name) == 0 || name[0] < 'A' || name[0] > 'Z' || journaldReservedNames[name] {
+		name = "FIELDS_" + strings.TrimLeft(name, "_")
+	}
+
+	if len(name) > journaldMaxName {
+		name = name[:journaldMaxName]
+	}
+	return strings.TrimRight(name, "_")
+}
+
+// Write the field in native protocol, use the binary format when value contains newline.
+func writeJournaldField(b *bytes.Buffer, key, value string) {
+	if key == "" {
+		return
+	}
+
+	if !strings.Contains(value, "\n") {
+		fmt.Fprintf(b, "%v=%v\n", key, value)
+		return
+	}
+
+	b.WriteString(key)
+	b.WriteString("\n")
+	binary.Write(b, binary.LittleEndian, uint64(len(value)))
+	b.WriteString(value)
+	b.WriteString("\n")
+}
+
+// Format the entry in native protocol.
+func (v *JournaldSink) format(e *Entry) []byte {
+	var b bytes.Buffer
+
+	writeJournaldField(&b, "MESSAGE", e.Message)
+	writeJournaldField(&b, "PRIORITY", fmt.Sprint(syslogSeverities[e.Level]))
+	writeJournaldField(&b, "SYSLOG_IDENTIFIER", v.Tag)
+	writeJournaldField(&b, "SYSLOG_PID", fmt.Sprint(e.Pid))
+	if e.HasCid {
+		writeJournaldField(&b, "CID", fmt.Sprint(e.Cid))
+	}
+	if f, ok := e.Caller(); ok {
+		writeJournaldField(&b, "CODE_FILE", f.File)
+		writeJournaldField(&b, "CODE_LINE", fmt.Sprint(f.Line))
+		writeJournaldField(&b, "CODE_FUNC", f.Function)
+	}
+
+	for _, k := range e.Fields.keys() {
+		writeJournaldField(&b, journaldName(k), fmt.Sprint(e.Fields[k]))
+	}
+
+	return b.Bytes()
+}
+
+// Interface Sink.
+// @remark the entry exceeds the max size of datagram is sent by file descriptor, see writeFile.
+func (v *JournaldSink) WriteEntry(e *Entry) (err error) {
+	msg := v.format(e)
+
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	if _, err = v.conn.Write(msg); err != nil && isMsgTooLarge(err) {
+		err = v.writeFile(msg)
+	}
+	return
+}
+
+// Interface callerSink, the caller is always sent as CODE_FILE, CODE_LINE and CODE_FUNC.
+func (v *JournaldSink) needCaller() bool {
+	return true
+}
+
+// Interface io.Closer.
+func (v *JournaldSink) Close() error {
+	return v.conn.Close()
+}
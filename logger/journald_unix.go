@@ -0,0 +1,76 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2013-2016 Oryx(ossrs)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package logger
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"syscall"
+)
+
+// Whether the datagram is too large to send.
+func isMsgTooLarge(err error) bool {
+	if err, ok := err.(*net.OpError); ok {
+		if err, ok := err.Err.(*os.SyscallError); ok {
+			return err.Err == syscall.EMSGSIZE || err.Err == syscall.ENOBUFS
+		}
+	}
+	return false
+}
+
+// Send the entry by the file descriptor of a deleted file in /dev/shm, with lock held,
+// for the entry exceeds the max size of datagram.
+// @see https://systemd.io/JOURNAL_NATIVE_PROTOCOL/
+func (v *JournaldSink) writeFile(msg []byte) (err error) {
+	var f *os.File
+	if f, err = ioutil.TempFile("/dev/shm", "journald-"); err != nil {
+		return
+	}
+	defer f.Close()
+
+	if err = os.Remove(f.Name()); err != nil {
+		return
+	}
+	if _, err = f.Write(msg); err != nil {
+		return
+	}
+
+	// the conn is connected, which can't WriteMsgUnix, so send by the raw fd.
+	var rc syscall.RawConn
+	if rc, err = v.conn.SyscallConn(); err != nil {
+		return
+	}
+
+	oob := syscall.UnixRights(int(f.Fd()))
+	var serr error
+	if err = rc.Write(func(fd uintptr) bool {
+		serr = syscall.Sendmsg(int(fd), nil, oob, nil, 0)
+		return serr != syscall.EAGAIN
+	}); err != nil {
+		return
+	}
+	return serr
+}
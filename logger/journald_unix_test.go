@@ -0,0 +1,90 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2013-2016 Oryx(ossrs)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package logger
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestJournaldSink_Large(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logger")
+	if err != nil {
+		t.Fatalf("create dir failed, err is %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "journal.sock")
+	l, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("listen failed, err is %v", err)
+	}
+	defer l.Close()
+
+	s, err := NewJournaldSink(path, "srs")
+	if err != nil {
+		t.Fatalf("create sink failed, err is %v", err)
+	}
+	defer s.Close()
+
+	// The entry exceeds the max size of datagram is sent by fd.
+	large := strings.Repeat("x", 1024*1024)
+	if err := s.WriteEntry(NewEntry(LevelTrace, nil, large)); err != nil {
+		t.Errorf("write failed, err is %v", err)
+	}
+
+	b, oob := make([]byte, 4096), make([]byte, 4096)
+	n, oobn, _, _, err := l.ReadMsgUnix(b, oob)
+	if err != nil {
+		t.Fatalf("read failed, err is %v", err)
+	} else if n != 0 {
+		t.Errorf("invalid size %v", n)
+	}
+
+	msgs, err := syscall.ParseSocketControlMessage(oob[:oobn])
+	if err != nil || len(msgs) != 1 {
+		t.Fatalf("parse control message failed, err is %v", err)
+	}
+	fds, err := syscall.ParseUnixRights(&msgs[0])
+	if err != nil || len(fds) != 1 {
+		t.Fatalf("parse fds failed, err is %v", err)
+	}
+
+	f := os.NewFile(uintptr(fds[0]), "journal")
+	defer f.Close()
+
+	if _, err := f.Seek(0, 0); err != nil {
+		t.Errorf("seek failed, err is %v", err)
+	} else if b, err := ioutil.ReadAll(f); err != nil {
+		t.Errorf("read failed, err is %v", err)
+	} else if !strings.HasPrefix(string(b), "MESSAGE="+large+"\n") {
+		t.Errorf("invalid entry size %v", len(b))
+	}
+}
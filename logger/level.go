@@ -0,0 +1,169 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2013-2016 Oryx(ossrs)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package logger
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+)
+
+// The level of logger, the log lower than current level is discard.
+type Level int
+
+const (
+	// Info, the verbose info level, very detail log.
+	LevelInfo Level = iota
+	// Trace, something important, the default level.
+	LevelTrace
+	// Warn, dangerous information.
+	LevelWarn
+	// Error, fatal error things.
+	LevelError
+)
+
+func (v Level) String() string {
+	switch v {
+	case LevelInfo:
+		return "info"
+	case LevelTrace:
+		return "trace"
+	case LevelWarn:
+		return "warn"
+	case LevelError:
+		return "error"
+	default:
+		return fmt.Sprintf("Level(%d)", int(v))
+	}
+}
+
+// Parse the level from string, for example, info, trace, warn or error, ignore case.
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "info", "verbose":
+		return LevelInfo, nil
+	case "trace":
+		return LevelTrace, nil
+	case "warn", "warning":
+		return LevelWarn, nil
+	case "error":
+		return LevelError, nil
+	default:
+		return LevelTrace, fmt.Errorf("invalid level %v", s)
+	}
+}
+
+// The current level, default to trace.
+var level = LevelTrace
+
+// Get the current level.
+func GetLevel() Level {
+	lock.Lock()
+	defer lock.Unlock()
+
+	return level
+}
+
+// Set the level, the log lower than level is discard,
+// for example, set to LevelInfo to get the verbose logs.
+func SetLevel(l Level) {
+	if l < LevelInfo || l > LevelError {
+		return
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	level = l
+	apply()
+}
+
+// The http handler to get or set the level at runtime, for example:
+//
+//	http.Handle("/api/v1/log/level", logger.NewLevelHandler(nil))
+//
+// Then get the level:
+//
+//	curl http://127.0.0.1:1985/api/v1/log/level
+//
+// Or set the level to info:
+//
+//	curl -X POST http://127.0.0.1:1985/api/v1/log/level?level=info
+//
+// The response is the json {"level":"info"}.
+func NewLevelHandler(ctx Context) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" || r.Method == "PUT" {
+			l, err := ParseLevel(r.FormValue("level"))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			if previous := GetLevel(); previous != l {
+				SetLevel(l)
+				T(ctx, "Change log level from", previous, "to", l, "by", r.RemoteAddr)
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"level":"%v"}`, GetLevel())
+	})
+}
+
+// Toggle the level between info and current level when got signal,
+// for example, kill -USR2 to get the verbose logs, then kill -USR2 again to restore.
+// @remark user should call the returned stop to stop the signal.
+func ToggleLevelOnSignal(ctx Context, signals ...os.Signal) (stop func()) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, signals...)
+
+	done := make(chan bool)
+	go func() {
+		previous := GetLevel()
+		for {
+			select {
+			case s := <-c:
+				if current := GetLevel(); current != LevelInfo {
+					previous = current
+					SetLevel(LevelInfo)
+				} else {
+					SetLevel(previous)
+				}
+				T(ctx, "Toggle log level to", GetLevel(), "by signal", s)
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			signal.Stop(c)
+			close(done)
+		})
+	}
+}
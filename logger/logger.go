@@ -25,6 +25,8 @@
 //		logger.Warn.Println(Context, ...)
 //		logger.Error.Println(Context, ...)
 // @remark the Context is optional thus can be nil.
+// @remark use SetLevel to change the level at runtime, default to trace.
+// @remark use SetCaller to log the file:line of caller, SetErrorStack to attach stack to error.
 package logger
 
 import (
@@ -33,6 +35,8 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
+	"sync"
+	"sync/atomic"
 )
 
 // default level for logger.
@@ -43,6 +47,12 @@ const (
 	logErrorLabel = "[error] "
 )
 
+// the label of each level.
+var labels = [...]string{logInfoLabel, logTraceLabel, logWarnLabel, logErrorLabel}
+
+// the underlayer io of each level, info and trace to stdout, warn and error to stderr.
+var writers = [...]io.Writer{os.Stdout, os.Stdout, os.Stderr, os.Stderr}
+
 // the context for current goroutine.
 type Context interface {
 	// get current goroutine cid.
@@ -52,6 +62,9 @@ type Context interface {
 // the LOG+ which provides connection-based log.
 type loggerPlus struct {
 	logger *log.Logger
+	// whether log the caller and stack.
+	caller bool
+	stack  bool
 }
 
 func NewLoggerPlus(l *log.Logger) Logger {
@@ -59,16 +72,41 @@ func NewLoggerPlus(l *log.Logger) Logger {
 }
 
 func (v *loggerPlus) Println(ctx Context, a ...interface{}) {
-	if ctx == nil {
-		a = append([]interface{}{fmt.Sprintf("[%v]", os.Getpid())}, a...)
-	} else {
-		a = append([]interface{}{fmt.Sprintf("[%v][%v]", os.Getpid(), ctx.Cid())}, a...)
+	// the fields at the end of line.
+	if args, fields := logFields(ctx, a); fields != nil {
+		if a = args; len(fields) > 0 {
+			a = append(a, fields.String())
+		}
+	}
+
+	var pcs []uintptr
+	if v.caller || v.stack {
+		pcs = callers()
+	}
+
+	prefix := fmt.Sprintf("[%v]", os.Getpid())
+	if ctx != nil {
+		prefix += fmt.Sprintf("[%v]", ctx.Cid())
+	}
+	if v.caller {
+		if f, ok := callerFrame(pcs); ok {
+			prefix += fmt.Sprintf("[%v]", shortFileLine(f))
+		}
+		prefix += fmt.Sprintf("[g%v]", goroutineID())
+	}
+	a = append([]interface{}{prefix}, a...)
+
+	if !v.stack {
+		v.logger.Println(a...)
+		return
 	}
-	v.logger.Println(a...)
+
+	// the stack follows the line, each frame in two lines.
+	v.logger.Print(fmt.Sprintln(a...) + callerStack(pcs))
 }
 
-// Info, the verbose info level, very detail log, the lowest level, to discard.
-var Info Logger
+// Info, the verbose info level, very detail log, the lowest level, discard by default.
+var Info Logger = &levelLogger{level: LevelInfo}
 
 // Alias for Info level println.
 func I(ctx Context, a ...interface{}) {
@@ -76,7 +114,7 @@ func I(ctx Context, a ...interface{}) {
 }
 
 // Trace, the trace level, something important, the default log level, to stdout.
-var Trace Logger
+var Trace Logger = &levelLogger{level: LevelTrace}
 
 // Alias for Trace level println.
 func T(ctx Context, a ...interface{}) {
@@ -84,7 +122,7 @@ func T(ctx Context, a ...interface{}) {
 }
 
 // Warn, the warning level, dangerous information, to stderr.
-var Warn Logger
+var Warn Logger = &levelLogger{level: LevelWarn}
 
 // Alias for Warn level println.
 func W(ctx Context, a ...interface{}) {
@@ -92,7 +130,7 @@ func W(ctx Context, a ...interface{}) {
 }
 
 // Error, the error level, fatal error things, ot stderr.
-var Error Logger
+var Error Logger = &levelLogger{level: LevelError}
 
 // Alias for Error level println.
 func E(ctx Context, a ...interface{}) {
@@ -107,20 +145,161 @@ type Logger interface {
 }
 
 func init() {
-	Info = NewLoggerPlus(log.New(ioutil.Discard, logInfoLabel, log.Ldate|log.Ltime|log.Lmicroseconds))
-	Trace = NewLoggerPlus(log.New(os.Stdout, logTraceLabel, log.Ldate|log.Ltime|log.Lmicroseconds))
-	Warn = NewLoggerPlus(log.New(os.Stderr, logWarnLabel, log.Ldate|log.Ltime|log.Lmicroseconds))
-	Error = NewLoggerPlus(log.New(os.Stderr, logErrorLabel, log.Ldate|log.Ltime|log.Lmicroseconds))
+	apply()
+}
+
+// The backend of loggers, which is swapped atomically when switch or close,
+// so it's safe to log when switching.
+type backend struct {
+	loggers [4]Logger
+	// the number of writing logs, so the io is closed after logs done.
+	// @remark never hold the lock when writing, for the writer may log again.
+	lock    *sync.Mutex
+	cond    *sync.Cond
+	writing int
+	closed  bool
+}
+
+func newBackend() *backend {
+	v := &backend{lock: &sync.Mutex{}}
+	v.cond = sync.NewCond(v.lock)
+	return v
+}
+
+// Start to write log, @return false when closed.
+func (v *backend) enter() bool {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	if v.closed {
+		return false
+	}
+	v.writing++
+	return true
+}
+
+// The log is written.
+func (v *backend) leave() {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	if v.writing--; v.writing == 0 && v.closed {
+		v.cond.Broadcast()
+	}
+}
+
+// Close the backend and wait for the writing logs done,
+// @remark the log after closed retry the current backend, so never block the logs in writer.
+func (v *backend) close() {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	v.closed = true
+	for v.writing > 0 {
+		v.cond.Wait()
+	}
+}
+
+// The current backend.
+var current atomic.Value
+
+// The logger of level, which write to the current backend.
+type levelLogger struct {
+	level Level
+}
+
+func (v *levelLogger) Println(ctx Context, a ...interface{}) {
+	for {
+		b := current.Load().(*backend)
+
+		// the backend is closed after loaded, retry the current one.
+		if !b.enter() {
+			continue
+		}
+		defer b.leave()
+
+		b.loggers[v.level].Println(ctx, a...)
+		return
+	}
+}
+
+// The writer shared by loggers of levels, to write one by one.
+type syncWriter struct {
+	lock *sync.Mutex
+	w    io.Writer
+}
+
+func (v *syncWriter) Write(p []byte) (int, error) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	return v.w.Write(p)
+}
+
+// The lock for the settings, the writers, level, format, caller and previous io.
+var lock = &sync.Mutex{}
+
+// Create the logger of level and format, to discard when level is lower than current level.
+func newLogger(l Level) Logger {
+	if sink != nil {
+		if l < level {
+			return &loggerSink{sink: discardSink{}, level: l}
+		}
+		return &loggerSink{sink: sink, level: l, caller: sinkCaller(sink, l)}
+	}
+
+	w := writers[l]
+	if l < level {
+		w = ioutil.Discard
+	}
+
+	return newWriterLogger(w, l)
+}
+
+// Create the logger of level to writer, in current format, with lock held.
+func newWriterLogger(w io.Writer, l Level) Logger {
+	if format == FormatJson {
+		return newLoggerJson(w, l, caller, errorStack && l == LevelError)
+	}
+	return &loggerPlus{
+		logger: log.New(w, labels[l], log.Ldate|log.Ltime|log.Lmicroseconds),
+		caller: caller, stack: errorStack && l == LevelError,
+	}
+}
+
+// Apply the level, format and writers to a new backend, with lock held,
+// @return the previous backend, which is closed when all writing logs done.
+func apply() *backend {
+	// the router write to writers in current format.
+	if r, ok := sink.(*Router); ok {
+		r.apply()
+	}
+
+	b := newBackend()
+	for l := LevelInfo; l <= LevelError; l++ {
+		b.loggers[l] = newLogger(l)
+	}
+
+	previous, _ := current.Load().(*backend)
+	current.Store(b)
+
+	if previous != nil {
+		previous.close()
+	}
+
+	return previous
 }
 
 // Switch the underlayer io.
 // @remark user must close previous io for logger never close it.
 func Switch(w io.Writer) {
-	// TODO: support level, default to trace here.
-	Info = NewLoggerPlus(log.New(ioutil.Discard, logInfoLabel, log.Ldate|log.Ltime|log.Lmicroseconds))
-	Trace = NewLoggerPlus(log.New(w, logTraceLabel, log.Ldate|log.Ltime|log.Lmicroseconds))
-	Warn = NewLoggerPlus(log.New(w, logWarnLabel, log.Ldate|log.Ltime|log.Lmicroseconds))
-	Error = NewLoggerPlus(log.New(w, logErrorLabel, log.Ldate|log.Ltime|log.Lmicroseconds))
+	lock.Lock()
+	defer lock.Unlock()
+
+	sw := &syncWriter{lock: &sync.Mutex{}, w: w}
+	writers = [...]io.Writer{sw, sw, sw, sw}
+	sink = nil
+	apply()
 
 	if w, ok := w.(io.Closer); ok {
 		previousIo = w
@@ -132,11 +311,14 @@ var previousIo io.Closer
 
 // The interface io.Closer
 // Cleanup the logger, discard any log util switch to fresh writer.
+// @remark it's safe to log when closing, the io is closed after the writing logs done.
 func Close() (err error) {
-	Info = NewLoggerPlus(log.New(ioutil.Discard, logInfoLabel, log.Ldate|log.Ltime|log.Lmicroseconds))
-	Trace = NewLoggerPlus(log.New(ioutil.Discard, logTraceLabel, log.Ldate|log.Ltime|log.Lmicroseconds))
-	Warn = NewLoggerPlus(log.New(ioutil.Discard, logWarnLabel, log.Ldate|log.Ltime|log.Lmicroseconds))
-	Error = NewLoggerPlus(log.New(ioutil.Discard, logErrorLabel, log.Ldate|log.Ltime|log.Lmicroseconds))
+	lock.Lock()
+	defer lock.Unlock()
+
+	writers = [...]io.Writer{ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard}
+	sink = nil
+	apply()
 
 	if previousIo != nil {
 		err = previousIo.Close()
@@ -21,7 +21,410 @@
 
 package logger
 
-import "testing"
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
 
 func TestLogger(t *testing.T) {
 }
+
+func TestParseLevel(t *testing.T) {
+	for s, l := range map[string]Level{
+		"info": LevelInfo, "Trace": LevelTrace, "WARN": LevelWarn, "warning": LevelWarn, " error ": LevelError,
+	} {
+		if v, err := ParseLevel(s); err != nil || v != l {
+			t.Errorf("parse %v failed, level=%v, err is %v", s, v, err)
+		} else if v, err := ParseLevel(l.String()); err != nil || v != l {
+			t.Errorf("parse %v failed, level=%v, err is %v", l, v, err)
+		}
+	}
+
+	if _, err := ParseLevel("debug"); err == nil {
+		t.Errorf("should fail for invalid level")
+	}
+}
+
+func TestSetLevel(t *testing.T) {
+	defer SetLevel(GetLevel())
+	defer Close()
+
+	var b bytes.Buffer
+	Switch(&b)
+
+	I(nil, "info log")
+	T(nil, "trace log")
+	if v := b.String(); strings.Contains(v, "info log") || !strings.Contains(v, "trace log") {
+		t.Errorf("invalid log %v", v)
+	}
+
+	b.Reset()
+	SetLevel(LevelInfo)
+	I(nil, "info log")
+	if v := b.String(); !strings.Contains(v, "[info] ") || !strings.Contains(v, "info log") {
+		t.Errorf("invalid log %v", v)
+	}
+
+	b.Reset()
+	SetLevel(LevelError)
+	W(nil, "warn log")
+	E(nil, "error log")
+	if v := b.String(); strings.Contains(v, "warn log") || !strings.Contains(v, "error log") {
+		t.Errorf("invalid log %v", v)
+	}
+
+	if SetLevel(Level(100)); GetLevel() != LevelError {
+		t.Errorf("invalid level %v", GetLevel())
+	}
+}
+
+func TestNewLevelHandler(t *testing.T) {
+	defer SetLevel(GetLevel())
+	defer Close()
+	Switch(&bytes.Buffer{})
+
+	h := NewLevelHandler(nil)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/log/level?level=info", nil))
+	if v := w.Body.String(); v != `{"level":"info"}` || GetLevel() != LevelInfo {
+		t.Errorf("invalid response %v, level=%v", v, GetLevel())
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/log/level?level=error", nil))
+	if v := w.Body.String(); v != `{"level":"info"}` {
+		t.Errorf("invalid response %v", v)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("PUT", "/api/v1/log/level?level=debug", nil))
+	if w.Code != 400 || GetLevel() != LevelInfo {
+		t.Errorf("invalid code %v, level=%v", w.Code, GetLevel())
+	}
+}
+
+type cid int
+
+func (v cid) Cid() int {
+	return int(v)
+}
+
+func TestFields_Text(t *testing.T) {
+	defer Close()
+
+	var b bytes.Buffer
+	Switch(&b)
+
+	T(cid(7), "publish", Fields{"stream": "livestream", "app": "live"}, "ok", Fields{})
+	if v := b.String(); !strings.Contains(v, "[7] publish ok app=live stream=livestream\n") {
+		t.Errorf("invalid log %v", v)
+	}
+}
+
+func TestSetFormat(t *testing.T) {
+	defer SetFormat(GetFormat())
+	defer Close()
+
+	var b bytes.Buffer
+	Switch(&b)
+	SetFormat(FormatJson)
+
+	T(cid(7), "publish", "stream", Fields{"stream": "livestream", "msg": "hello", "size": 100, "level": "debug", "fields.level": "user"})
+	E(nil, "failed", Fields{"err": fmt.Errorf("timeout")})
+
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != 2 {
+		t.Errorf("invalid log %v", b.String())
+		return
+	}
+
+	var v map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &v); err != nil {
+		t.Errorf("invalid log %v, err is %v", lines[0], err)
+	} else if v["level"] != "trace" || v["cid"] != float64(7) || v["pid"] != float64(os.Getpid()) || v["time"] == nil {
+		t.Errorf("invalid log %v", lines[0])
+	} else if v["msg"] != "publish stream" || v["stream"] != "livestream" || v["fields.msg"] != "hello" || v["size"] != float64(100) {
+		t.Errorf("invalid log %v", lines[0])
+	} else if v["fields.level"] != "user" || v["fields.fields.level"] != "debug" {
+		t.Errorf("invalid log %v", lines[0])
+	}
+
+	v = nil
+	if err := json.Unmarshal([]byte(lines[1]), &v); err != nil {
+		t.Errorf("invalid log %v, err is %v", lines[1], err)
+	} else if _, ok := v["cid"]; ok || v["level"] != "error" || v["err"] != "timeout" {
+		t.Errorf("invalid log %v", lines[1])
+	}
+}
+
+func TestSetCaller(t *testing.T) {
+	defer SetCaller(GetCaller())
+	defer SetErrorStack(GetErrorStack())
+	defer Close()
+
+	var b bytes.Buffer
+	Switch(&b)
+	SetCaller(true)
+	SetErrorStack(true)
+
+	pc, _, line, _ := runtime.Caller(0)
+	T(cid(7), "publish")
+	W(nil, "slow")
+	E(nil, "failed")
+
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) < 4 {
+		t.Errorf("invalid log %v", b.String())
+		return
+	}
+
+	expect := fmt.Sprintf("[%v][7][logger_test.go:%v][g", os.Getpid(), line+1)
+	if v := lines[0]; !strings.Contains(v, expect) || !strings.HasSuffix(v, "] publish") {
+		t.Errorf("invalid log %v, expect %v", v, expect)
+	}
+	if v := lines[1]; !strings.Contains(v, fmt.Sprintf("][logger_test.go:%v][g", line+2)) {
+		t.Errorf("invalid log %v", v)
+	}
+	if v := lines[2]; !strings.Contains(v, fmt.Sprintf("][logger_test.go:%v][g", line+3)) || !strings.HasSuffix(v, "] failed") {
+		t.Errorf("invalid log %v", v)
+	}
+
+	// the stack is attached to error only, without frames of logger,
+	// the import path of package is from the caller, for the package maybe vendored.
+	stack := strings.Join(lines[3:], "\n")
+	if !strings.HasPrefix(stack, runtime.FuncForPC(pc).Name()+"(...)\n\t") {
+		t.Errorf("invalid stack %v", stack)
+	}
+	if strings.Contains(stack, "levelLogger") || strings.Contains(stack, "loggerPlus") {
+		t.Errorf("invalid stack %v", stack)
+	}
+
+	b.Reset()
+	SetFormat(FormatJson)
+	defer SetFormat(FormatText)
+
+	_, _, line, _ = runtime.Caller(0)
+	E(nil, "failed", Fields{"caller": "none"})
+
+	var v map[string]interface{}
+	if err := json.Unmarshal(b.Bytes(), &v); err != nil {
+		t.Errorf("invalid log %v, err is %v", b.String(), err)
+	} else if v["caller"] != fmt.Sprintf("logger_test.go:%v", line+1) || v["fields.caller"] != "none" {
+		t.Errorf("invalid log %v", b.String())
+	} else if g, ok := v["goroutine"].(float64); !ok || g <= 0 {
+		t.Errorf("invalid log %v", b.String())
+	} else if s, ok := v["stack"].(string); !ok || !strings.Contains(s, "TestSetCaller") {
+		t.Errorf("invalid log %v", b.String())
+	}
+}
+
+func TestTraceContext(t *testing.T) {
+	root := NewTraceContext("req-1", "", Fields{"ip": "10.0.0.1"})
+	if root.TraceID() != "req-1" || root.ParentID() != "" || root.Fields()["ip"] != "10.0.0.1" {
+		t.Errorf("invalid root %v", root)
+	}
+
+	child := NewChildContext(root, Fields{"stream": "livestream"})
+	if child.Cid() == root.Cid() || child.TraceID() != "req-1" || child.ParentID() != fmt.Sprint(root.Cid()) {
+		t.Errorf("invalid child %v of %v", child, root)
+	} else if child.Fields()["ip"] != "10.0.0.1" || child.Fields()["stream"] != "livestream" || len(root.Fields()) != 1 {
+		t.Errorf("invalid child %v of %v", child, root)
+	}
+
+	// The child of legacy context has new trace id.
+	if v := NewChildContext(cid(7), nil); v.TraceID() == "" || v.ParentID() != "7" {
+		t.Errorf("invalid child %v", v)
+	} else if v := NewChildContext(nil, nil); v.TraceID() == "" || v.ParentID() != "" {
+		t.Errorf("invalid child %v", v)
+	} else if NewTraceID() == NewTraceID() || len(NewTraceID()) != 32 {
+		t.Errorf("invalid trace id")
+	}
+
+	ctx := WithContext(context.Background(), child)
+	if v := FromContext(ctx); v != child {
+		t.Errorf("invalid context %v", v)
+	} else if v := FromContext(context.Background()); v != nil {
+		t.Errorf("invalid context %v", v)
+	}
+}
+
+func TestTraceContext_Log(t *testing.T) {
+	defer SetFormat(GetFormat())
+	defer Close()
+
+	var b bytes.Buffer
+	Switch(&b)
+
+	ctx := NewChildContext(NewTraceContext("req-1", "", Fields{"ip": "10.0.0.1"}), nil)
+	T(ctx, "play", Fields{"ip": "10.0.0.2"})
+	if v, expect := b.String(), fmt.Sprintf("[%v] play ip=10.0.0.2 parent_id=%v trace_id=req-1\n", ctx.Cid(), ctx.ParentID()); !strings.HasSuffix(v, expect) {
+		t.Errorf("invalid log %v, expect %v", v, expect)
+	}
+
+	b.Reset()
+	SetFormat(FormatJson)
+	T(ctx, "play")
+
+	var v map[string]interface{}
+	if err := json.Unmarshal(b.Bytes(), &v); err != nil {
+		t.Errorf("invalid log %v, err is %v", b.String(), err)
+	} else if v["trace_id"] != "req-1" || v["parent_id"] != ctx.ParentID() || v["ip"] != "10.0.0.1" || v["cid"] != float64(ctx.Cid()) {
+		t.Errorf("invalid log %v", b.String())
+	}
+
+	// The trace id is not overridden by fields, which is moved aside.
+	b.Reset()
+	T(ctx, "play", Fields{"trace_id": "user", "fields.trace_id": "other"})
+
+	v = nil
+	if err := json.Unmarshal(b.Bytes(), &v); err != nil {
+		t.Errorf("invalid log %v, err is %v", b.String(), err)
+	} else if v["trace_id"] != "req-1" || v["fields.fields.trace_id"] != "user" || v["fields.trace_id"] != "other" {
+		t.Errorf("invalid log %v", b.String())
+	}
+}
+
+// The writer which fail when write after closed.
+type closeChecker struct {
+	lock    sync.Mutex
+	closed  bool
+	lines   int
+	invalid int
+}
+
+func (v *closeChecker) Write(p []byte) (int, error) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	if v.closed {
+		v.invalid++
+		return 0, fmt.Errorf("closed")
+	}
+	v.lines++
+	return len(p), nil
+}
+
+func (v *closeChecker) Close() error {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	v.closed = true
+	return nil
+}
+
+// The writer may log again when writing, to the backend which is closing.
+func TestSwitch_Reentrant(t *testing.T) {
+	defer Close()
+
+	b := current.Load().(*backend)
+	if !b.enter() {
+		t.Error("should enter")
+		return
+	}
+
+	done := make(chan bool)
+	go func() {
+		Switch(ioutil.Discard)
+		close(done)
+	}()
+
+	// wait for the backend to close, which wait for the writing log.
+	for closed := false; !closed; {
+		b.lock.Lock()
+		closed = b.closed
+		b.lock.Unlock()
+	}
+
+	nested := make(chan bool)
+	go func() {
+		if b.enter() {
+			t.Error("should not enter closed backend")
+		}
+		T(nil, "log in writer")
+		close(nested)
+	}()
+
+	select {
+	case <-nested:
+	case <-time.After(3 * time.Second):
+		t.Error("deadlock when log in writer")
+	}
+
+	b.leave()
+	<-done
+}
+
+// Run with -race to check the log when switching.
+func TestSwitch_Race(t *testing.T) {
+	defer SetFormat(GetFormat())
+	defer SetLevel(GetLevel())
+	defer Close()
+
+	done := make(chan bool)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			ctx := NewTraceContext("", "", Fields{"goroutine": i})
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+
+				I(ctx, "info log")
+				T(ctx, "trace log", Fields{"n": i})
+				W(nil, "warn log")
+				Error.Println(ctx, "error log")
+			}
+		}(i)
+	}
+
+	var writers []*closeChecker
+	for i := 0; i < 100; i++ {
+		w := &closeChecker{}
+		writers = append(writers, w)
+
+		Switch(w)
+		SetLevel(Level(i % 4))
+		SetFormat(Format(i % 2))
+		_ = GetLevel()
+		_ = GetFormat()
+
+		if err := Close(); err != nil {
+			t.Errorf("close failed, err is %v", err)
+		}
+	}
+
+	close(done)
+	wg.Wait()
+
+	for _, w := range writers {
+		if w.invalid > 0 {
+			t.Errorf("%v lines written after closed", w.invalid)
+		}
+	}
+}
+
+func TestToggleLevelOnSignal(t *testing.T) {
+	stop := ToggleLevelOnSignal(nil, syscall.SIGUSR2)
+
+	// Stop twice should not panic.
+	stop()
+	stop()
+}
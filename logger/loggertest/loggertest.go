@@ -0,0 +1,152 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2013-2016 Oryx(ossrs)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// The loggertest package provides the recorder to capture the logs in test:
+//
+//	func TestXxx(t *testing.T) {
+//		r := loggertest.New(t)
+//		// the code under test which log by logger.
+//		r.AssertLogged(t, logger.LevelError, "failed")
+//	}
+//
+// @remark the recorder replace the global logger, so never use it in parallel tests.
+package loggertest
+
+import (
+	ol "github.com/ossrs/go-oryx-lib/logger"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// The recorder to capture the logs of all levels.
+type Recorder struct {
+	lock    *sync.Mutex
+	entries []*ol.Entry
+}
+
+// Install the recorder for test, which is restored to previous logger when test finished.
+func New(t testing.TB) *Recorder {
+	v := NewRecorder()
+
+	restore := ol.ReplaceSink(v)
+	t.Cleanup(restore)
+
+	return v
+}
+
+// Create the recorder, user should install it by logger.ReplaceSink.
+func NewRecorder() *Recorder {
+	return &Recorder{lock: &sync.Mutex{}}
+}
+
+// Interface logger.Sink.
+func (v *Recorder) WriteEntry(e *ol.Entry) error {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	v.entries = append(v.entries, e)
+	return nil
+}
+
+// Get the captured entries.
+func (v *Recorder) Entries() []*ol.Entry {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	return append([]*ol.Entry(nil), v.entries...)
+}
+
+// Discard the captured entries.
+func (v *Recorder) Reset() {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	v.entries = nil
+}
+
+// Get the entries of level which message contains substr.
+func (v *Recorder) Find(level ol.Level, substr string) []*ol.Entry {
+	var entries []*ol.Entry
+	for _, e := range v.Entries() {
+		if e.Level == level && strings.Contains(e.Message, substr) {
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
+
+// Get the entries of cid and level which message contains substr.
+func (v *Recorder) FindCid(cid int, level ol.Level, substr string) []*ol.Entry {
+	var entries []*ol.Entry
+	for _, e := range v.Find(level, substr) {
+		if e.HasCid && e.Cid == cid {
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
+
+// Whether logged the message contains substr at level.
+func (v *Recorder) Logged(level ol.Level, substr string) bool {
+	return len(v.Find(level, substr)) > 0
+}
+
+// Assert logged the message contains substr at level.
+func (v *Recorder) AssertLogged(t testing.TB, level ol.Level, substr string) {
+	t.Helper()
+
+	if !v.Logged(level, substr) {
+		t.Errorf("no %v log contains %q in %v", level, substr, v)
+	}
+}
+
+// Assert logged the message contains substr at level for cid.
+func (v *Recorder) AssertLoggedCid(t testing.TB, cid int, level ol.Level, substr string) {
+	t.Helper()
+
+	if len(v.FindCid(cid, level, substr)) == 0 {
+		t.Errorf("no %v log of cid %v contains %q in %v", level, cid, substr, v)
+	}
+}
+
+// Assert not logged the message contains substr at level.
+func (v *Recorder) AssertNotLogged(t testing.TB, level ol.Level, substr string) {
+	t.Helper()
+
+	if entries := v.Find(level, substr); len(entries) > 0 {
+		t.Errorf("unexpected %v log contains %q, %v", level, substr, entries[0].Message)
+	}
+}
+
+// Format the captured logs, one per line.
+func (v *Recorder) String() string {
+	lines := []string{}
+	for _, e := range v.Entries() {
+		line := "[" + e.Level.String() + "] "
+		if e.HasCid {
+			line += "[" + strconv.Itoa(e.Cid) + "] "
+		}
+		lines = append(lines, line+e.Message)
+	}
+	return "[" + strings.Join(lines, ", ") + "]"
+}
@@ -0,0 +1,73 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2013-2016 Oryx(ossrs)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package loggertest
+
+import (
+	"bytes"
+	ol "github.com/ossrs/go-oryx-lib/logger"
+	"strings"
+	"testing"
+)
+
+type cid int
+
+func (v cid) Cid() int {
+	return int(v)
+}
+
+func TestRecorder(t *testing.T) {
+	var b bytes.Buffer
+	ol.Switch(&b)
+	defer ol.Switch(nil)
+
+	t.Run("record", func(t *testing.T) {
+		r := New(t)
+
+		ol.T(nil, "trace message")
+		ol.E(cid(100), "open failed, err is", "EOF")
+
+		r.AssertLogged(t, ol.LevelTrace, "trace")
+		r.AssertLoggedCid(t, 100, ol.LevelError, "open failed")
+		r.AssertNotLogged(t, ol.LevelWarn, "failed")
+
+		if v := r.FindCid(200, ol.LevelError, "open failed"); len(v) != 0 {
+			t.Errorf("invalid entries %v", len(v))
+		}
+		if v := r.String(); v != "[[trace] trace message, [error] [100] open failed, err is EOF]" {
+			t.Errorf("invalid recorder %v", v)
+		}
+
+		r.Reset()
+		if v := r.Entries(); len(v) != 0 {
+			t.Errorf("invalid entries %v", len(v))
+		}
+	})
+
+	if b.Len() != 0 {
+		t.Errorf("should not write to previous writer, %v", b.String())
+	}
+
+	ol.T(nil, "restored")
+	if v := b.String(); !strings.Contains(v, "restored") {
+		t.Errorf("should restore writer, %v", v)
+	}
+}
@@ -0,0 +1,326 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2013-2016 Oryx(ossrs)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package logger
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The time format of backup file, for example, sys.log.2017-01-01T10-00-00.000000.
+const rotateTimeFormat = "2006-01-02T15-04-05.000000"
+
+// Rename the file to backup, replaced in test.
+var renameFile = os.Rename
+
+// The log file which rotate by size and time, keep some backups and gzip them,
+// and reopen for logrotate, for example:
+//
+//	f := logger.NewRotatingFile("sys.log")
+//	f.MaxSize, f.MaxBackups = 100*1024*1024, 10
+//	logger.Switch(f)
+//
+// @remark the lines are never dropped when rotate or reopen.
+type RotatingFile struct {
+	// The path of log file.
+	Path string
+	// Rotate when the size exceeds MaxSize in bytes, 0 to disable.
+	MaxSize int64
+	// Rotate every Interval since opened, for example, 24h, 0 to disable.
+	Interval time.Duration
+	// The max number of backups to keep, 0 to keep all.
+	MaxBackups int
+	// Whether gzip the backups, in background.
+	Compress bool
+	// The callback when failed but the line is written, for example, rotate failed in Write,
+	// or compress failed in background, nil to ignore.
+	// @remark it's called without lock, so it's ok to write log in callback.
+	OnError func(err error)
+
+	lock *sync.Mutex
+	file *os.File
+	// the size of file and the time opened.
+	size   int64
+	opened time.Time
+	// wait for the backups to compress and prune, one by one.
+	wg       *sync.WaitGroup
+	backLock *sync.Mutex
+}
+
+func NewRotatingFile(path string) *RotatingFile {
+	return &RotatingFile{
+		Path:     path,
+		lock:     &sync.Mutex{},
+		wg:       &sync.WaitGroup{},
+		backLock: &sync.Mutex{},
+	}
+}
+
+// Interface io.Writer, rotate when exceeds the MaxSize or Interval.
+func (v *RotatingFile) Write(p []byte) (n int, err error) {
+	// notify the error after unlock.
+	var rotateErr error
+	defer func() {
+		if rotateErr != nil {
+			v.onError(rotateErr)
+		}
+	}()
+
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	if v.file == nil {
+		if err = v.open(); err != nil {
+			return
+		}
+	}
+
+	if v.size > 0 && v.MaxSize > 0 && v.size+int64(len(p)) > v.MaxSize {
+		err = v.rotate()
+	} else if v.Interval > 0 && time.Now().Sub(v.opened) >= v.Interval {
+		err = v.rotate()
+	}
+	if err != nil {
+		if v.file == nil {
+			return
+		}
+		// the file is reopened when rotate failed, never drop the line.
+		rotateErr = fmt.Errorf("rotate log %v failed, err is %v", v.Path, err)
+	}
+
+	n, err = v.file.Write(p)
+	v.size += int64(n)
+	return
+}
+
+// Reopen the file, for example, the file is moved by logrotate.
+func (v *RotatingFile) Reopen() (err error) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	// the file is reopened even close failed, to keep writing.
+	var closeErr error
+	if v.file != nil {
+		closeErr = v.file.Close()
+		v.file = nil
+	}
+
+	if err = v.open(); err != nil {
+		return
+	}
+
+	if closeErr != nil {
+		return fmt.Errorf("close %v failed, err is %v", v.Path, closeErr)
+	}
+	return
+}
+
+// Rotate the file now.
+func (v *RotatingFile) Rotate() (err error) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	if v.file == nil {
+		if err = v.open(); err != nil {
+			return
+		}
+	}
+
+	return v.rotate()
+}
+
+// Reopen the file when got signal, for example, the SIGUSR1 from logrotate,
+// @remark user should call the returned stop to stop the signal.
+func (v *RotatingFile) ReopenOnSignal(signals ...os.Signal) (stop func()) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, signals...)
+
+	done := make(chan bool)
+	go func() {
+		for {
+			select {
+			case s := <-c:
+				if err := v.Reopen(); err != nil {
+					v.onError(fmt.Errorf("reopen log %v by signal %v failed, err is %v", v.Path, s, err))
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			signal.Stop(c)
+			close(done)
+		})
+	}
+}
+
+// Interface io.Closer, wait for the backups to compress.
+func (v *RotatingFile) Close() (err error) {
+	v.lock.Lock()
+	if v.file != nil {
+		err = v.file.Close()
+		v.file = nil
+	}
+	v.lock.Unlock()
+
+	v.wg.Wait()
+	return
+}
+
+func (v *RotatingFile) open() (err error) {
+	var f *os.File
+	if f, err = os.OpenFile(v.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644); err != nil {
+		return
+	}
+
+	var info os.FileInfo
+	if info, err = f.Stat(); err != nil {
+		f.Close()
+		return
+	}
+
+	v.file, v.size, v.opened = f, info.Size(), time.Now()
+	return
+}
+
+// Notify the error by OnError, without lock.
+func (v *RotatingFile) onError(err error) {
+	if v.OnError != nil {
+		v.OnError(err)
+	}
+}
+
+// Move the file to backup and open a new one,
+// @remark the file is moved and reopened even close failed, to keep writing.
+func (v *RotatingFile) rotate() (err error) {
+	closeErr := v.file.Close()
+	v.file = nil
+
+	backup := v.Path + "." + time.Now().Format(rotateTimeFormat)
+	if err = renameFile(v.Path, backup); err != nil {
+		// keep writing to the file, retry after another MaxSize bytes or Interval.
+		if err := v.open(); err != nil {
+			return err
+		}
+		v.size = 0
+		return fmt.Errorf("rename %v to %v failed, err is %v", v.Path, backup, err)
+	}
+
+	if err = v.open(); err != nil {
+		return
+	}
+
+	v.wg.Add(1)
+	go func() {
+		defer v.wg.Done()
+
+		v.backLock.Lock()
+		defer v.backLock.Unlock()
+
+		if v.Compress {
+			if err := compressFile(backup); err != nil {
+				v.onError(fmt.Errorf("compress log %v failed, err is %v", backup, err))
+			}
+		}
+
+		if err := v.prune(); err != nil {
+			v.onError(fmt.Errorf("prune log %v failed, err is %v", v.Path, err))
+		}
+	}()
+
+	if closeErr != nil {
+		return fmt.Errorf("close %v failed, err is %v", v.Path, closeErr)
+	}
+	return
+}
+
+// Remove the oldest backups which exceed the MaxBackups.
+func (v *RotatingFile) prune() (err error) {
+	if v.MaxBackups <= 0 {
+		return
+	}
+
+	var files []string
+	if files, err = filepath.Glob(v.Path + ".*"); err != nil {
+		return
+	}
+
+	var backups []string
+	for _, file := range files {
+		// the backup is sys.log.2017-01-01T10-00-00.000000 or with .gz
+		ts := strings.TrimSuffix(strings.TrimPrefix(file, v.Path+"."), ".gz")
+		if _, err := time.Parse(rotateTimeFormat, ts); err == nil {
+			backups = append(backups, file)
+		}
+	}
+
+	// the oldest first, for the time in name.
+	sort.Strings(backups)
+	for len(backups) > v.MaxBackups {
+		if err = os.Remove(backups[0]); err != nil && !os.IsNotExist(err) {
+			return
+		}
+		backups = backups[1:]
+	}
+
+	return nil
+}
+
+// Gzip the file to file.gz, then remove it.
+func compressFile(file string) (err error) {
+	var src *os.File
+	if src, err = os.Open(file); err != nil {
+		return
+	}
+	defer src.Close()
+
+	var dst *os.File
+	if dst, err = os.OpenFile(file+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644); err != nil {
+		return
+	}
+
+	w := gzip.NewWriter(dst)
+	if _, err = io.Copy(w, src); err == nil {
+		err = w.Close()
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(file + ".gz")
+		return
+	}
+
+	return os.Remove(file)
+}
@@ -0,0 +1,217 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2013-2016 Oryx(ossrs)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package logger
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestRotatingFile_MaxSize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logger")
+	if err != nil {
+		t.Fatalf("create dir failed, err is %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	f := NewRotatingFile(filepath.Join(dir, "sys.log"))
+	f.MaxSize, f.MaxBackups = 10, 2
+
+	// Each line rotate the file, except the first one.
+	for _, line := range []string{"line0\n", "line1\n", "line2\n", "line3\n"} {
+		if _, err := f.Write([]byte(line)); err != nil {
+			t.Errorf("write failed, err is %v", err)
+		}
+	}
+	if err := f.Close(); err != nil {
+		t.Errorf("close failed, err is %v", err)
+	}
+
+	if b, err := ioutil.ReadFile(f.Path); err != nil || string(b) != "line3\n" {
+		t.Errorf("invalid log %v, err is %v", string(b), err)
+	}
+
+	backups, _ := filepath.Glob(f.Path + ".*")
+	if len(backups) != 2 {
+		t.Errorf("invalid backups %v", backups)
+	} else if b, err := ioutil.ReadFile(backups[1]); err != nil || string(b) != "line2\n" {
+		t.Errorf("invalid backup %v, err is %v", string(b), err)
+	}
+}
+
+func TestRotatingFile_Compress(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logger")
+	if err != nil {
+		t.Fatalf("create dir failed, err is %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	f := NewRotatingFile(filepath.Join(dir, "sys.log"))
+	f.Compress = true
+
+	if _, err := f.Write([]byte("line0\n")); err != nil {
+		t.Errorf("write failed, err is %v", err)
+	} else if err := f.Rotate(); err != nil {
+		t.Errorf("rotate failed, err is %v", err)
+	} else if err := f.Close(); err != nil {
+		t.Errorf("close failed, err is %v", err)
+	}
+
+	backups, _ := filepath.Glob(f.Path + ".*")
+	if len(backups) != 1 || !strings.HasSuffix(backups[0], ".gz") {
+		t.Errorf("invalid backups %v", backups)
+		return
+	}
+
+	gz, _ := os.Open(backups[0])
+	defer gz.Close()
+
+	if r, err := gzip.NewReader(gz); err != nil {
+		t.Errorf("open gzip failed, err is %v", err)
+	} else if b, err := ioutil.ReadAll(r); err != nil || string(b) != "line0\n" {
+		t.Errorf("invalid backup %v, err is %v", string(b), err)
+	}
+}
+
+func TestRotatingFile_Reopen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logger")
+	if err != nil {
+		t.Fatalf("create dir failed, err is %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	f := NewRotatingFile(filepath.Join(dir, "sys.log"))
+	defer f.Close()
+
+	// The logrotate move the file, then notify to reopen.
+	f.Write([]byte("line0\n"))
+	if err := os.Rename(f.Path, f.Path+".1"); err != nil {
+		t.Errorf("rename failed, err is %v", err)
+	}
+	f.Write([]byte("line1\n"))
+	if err := f.Reopen(); err != nil {
+		t.Errorf("reopen failed, err is %v", err)
+	}
+	f.Write([]byte("line2\n"))
+
+	if b, err := ioutil.ReadFile(f.Path + ".1"); err != nil || string(b) != "line0\nline1\n" {
+		t.Errorf("invalid log %v, err is %v", string(b), err)
+	} else if b, err := ioutil.ReadFile(f.Path); err != nil || string(b) != "line2\n" {
+		t.Errorf("invalid log %v, err is %v", string(b), err)
+	}
+}
+
+func TestRotatingFile_RenameFailed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logger")
+	if err != nil {
+		t.Fatalf("create dir failed, err is %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	renameFile = func(from, to string) error {
+		return fmt.Errorf("rename %v denied", from)
+	}
+	defer func() {
+		renameFile = os.Rename
+	}()
+
+	var errs []error
+	f := NewRotatingFile(filepath.Join(dir, "sys.log"))
+	f.MaxSize = 10
+	f.OnError = func(err error) {
+		errs = append(errs, err)
+	}
+	defer f.Close()
+
+	// All lines are written to the file, even rotate failed.
+	for _, line := range []string{"line0\n", "line1\n", "line2\n"} {
+		if _, err := f.Write([]byte(line)); err != nil {
+			t.Errorf("write failed, err is %v", err)
+		}
+	}
+	if len(errs) == 0 {
+		t.Errorf("should notify the rotate error")
+	}
+
+	if err := f.Rotate(); err == nil {
+		t.Errorf("rotate should fail")
+	} else if _, err := f.Write([]byte("line3\n")); err != nil {
+		t.Errorf("write failed, err is %v", err)
+	}
+
+	if b, err := ioutil.ReadFile(f.Path); err != nil {
+		t.Errorf("read failed, err is %v", err)
+	} else if v := string(b); v != "line0\nline1\nline2\nline3\n" {
+		t.Errorf("invalid file %v", v)
+	}
+}
+
+func TestRotatingFile_CloseFailed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logger")
+	if err != nil {
+		t.Fatalf("create dir failed, err is %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	f := NewRotatingFile(filepath.Join(dir, "sys.log"))
+	defer f.Close()
+
+	if _, err := f.Write([]byte("line0\n")); err != nil {
+		t.Errorf("write failed, err is %v", err)
+	}
+
+	// The file is moved and reopened, even close failed.
+	f.file.Close()
+	if err := f.Rotate(); err == nil {
+		t.Errorf("rotate should fail")
+	} else if _, err := f.Write([]byte("line1\n")); err != nil {
+		t.Errorf("write failed, err is %v", err)
+	}
+
+	if b, err := ioutil.ReadFile(f.Path); err != nil {
+		t.Errorf("read failed, err is %v", err)
+	} else if v := string(b); v != "line1\n" {
+		t.Errorf("invalid file %v", v)
+	}
+
+	f.file.Close()
+	if err := f.Reopen(); err == nil {
+		t.Errorf("reopen should fail")
+	} else if _, err := f.Write([]byte("line2\n")); err != nil {
+		t.Errorf("write failed, err is %v", err)
+	}
+}
+
+func TestRotatingFile_ReopenOnSignal(t *testing.T) {
+	f := NewRotatingFile(filepath.Join(os.TempDir(), "sys.log"))
+	stop := f.ReopenOnSignal(syscall.SIGUSR1)
+
+	// Stop twice should not panic.
+	stop()
+	stop()
+}
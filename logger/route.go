@@ -0,0 +1,303 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2013-2016 Oryx(ossrs)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package logger
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sync"
+	"sync/atomic"
+)
+
+// The field key of module, in the fields of log or context, for example:
+//
+//	ctx := logger.NewTraceContext("", "", logger.Fields{logger.FieldModule: "rtmp"})
+const FieldModule = "module"
+
+// The rule to route the log to writer or sink.
+type Route struct {
+	// The levels to match, empty to match all.
+	Levels []Level
+	// The modules to match, the FieldModule of log, empty to match all.
+	Modules []string
+	// The cids to match, empty to match all.
+	Cids []int
+	// At most RateLimit logs per second for each call site, the others are dropped,
+	// for example, the noisy log in loop, 0 to disable.
+	RateLimit int
+
+	// Write the matched log to Writer in current format, see SetFormat, or to Sink.
+	Writer io.Writer
+	Sink   Sink
+
+	// the rate of call sites.
+	lock    *sync.Mutex
+	rates   map[uintptr]*routeRate
+	dropped uint64
+}
+
+// The rate of call site in a second.
+type routeRate struct {
+	second int64
+	count  int
+}
+
+// Get the number of logs dropped for rate limit.
+func (v *Route) Dropped() uint64 {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	return v.dropped
+}
+
+func (v *Route) match(e *Entry) bool {
+	if len(v.Levels) > 0 {
+		var ok bool
+		for _, l := range v.Levels {
+			if ok = l == e.Level; ok {
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+
+	if len(v.Modules) > 0 {
+		var ok bool
+		module := fmt.Sprint(e.Fields[FieldModule])
+		for _, m := range v.Modules {
+			if ok = m == module; ok {
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+
+	if len(v.Cids) > 0 {
+		var ok bool
+		for _, cid := range v.Cids {
+			if ok = e.HasCid && cid == e.Cid; ok {
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Whether the log of call site is allowed by rate limit.
+func (v *Route) allow(e *Entry) bool {
+	if v.RateLimit <= 0 {
+		return true
+	}
+
+	var pc uintptr
+	if f, ok := e.Caller(); ok {
+		pc = f.PC
+	}
+
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	second := e.Time.Unix()
+	r, ok := v.rates[pc]
+	if !ok || r.second != second {
+		// cleanup the call sites of previous seconds, keep the ones in current second.
+		if len(v.rates) > 1024 {
+			for k, r := range v.rates {
+				if r.second < second {
+					delete(v.rates, k)
+				}
+			}
+		}
+		r = &routeRate{second: second}
+		v.rates[pc] = r
+	}
+
+	if r.count >= v.RateLimit {
+		v.dropped++
+		return false
+	}
+	r.count++
+	return true
+}
+
+// The context of entry, for the logger of writer.
+type entryContext int
+
+func (v entryContext) Cid() int {
+	return int(v)
+}
+
+// Write the entry to sink, or to writer by loggers of levels.
+func (v *Route) write(e *Entry, loggers *[4]Logger) error {
+	if v.Sink != nil {
+		return v.Sink.WriteEntry(e)
+	}
+
+	var ctx Context
+	if e.HasCid {
+		ctx = entryContext(e.Cid)
+	}
+
+	if e.Fields != nil {
+		loggers[e.Level].Println(ctx, e.Message, e.Fields)
+	} else {
+		loggers[e.Level].Println(ctx, e.Message)
+	}
+	return nil
+}
+
+// The sink to route the logs by rules, for example, the errors to a separate file,
+// and the info of rtmp module to debug file, and others to stdout at most 10 per second per call site:
+//
+//	logger.SetLevel(logger.LevelInfo)
+//	logger.SwitchSink(logger.NewRouter(
+//		&logger.Route{Levels: []logger.Level{logger.LevelError}, Writer: errorFile},
+//		&logger.Route{Levels: []logger.Level{logger.LevelInfo}, Modules: []string{"rtmp"}, Writer: debugFile},
+//		&logger.Route{Levels: []logger.Level{logger.LevelTrace, logger.LevelWarn}, Writer: os.Stdout, RateLimit: 10},
+//	))
+//
+// @remark the log is written to all matched routes.
+// @remark the log lower than level is discard before route, see SetLevel.
+type Router struct {
+	routes []*Route
+	// the loggers of levels for each route to writer, rebuilt by apply, see SetFormat.
+	loggers atomic.Value
+}
+
+func NewRouter(routes ...*Route) *Router {
+	for _, r := range routes {
+		r.lock = &sync.Mutex{}
+		r.rates = make(map[uintptr]*routeRate)
+	}
+
+	v := &Router{routes: routes}
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	v.apply()
+	return v
+}
+
+// Build the loggers of writers in current format, with lock held,
+// the routes to the same writer share one syncWriter, to write line by line.
+func (v *Router) apply() {
+	writers := make(map[io.Writer]*syncWriter)
+	loggers := make([][4]Logger, len(v.routes))
+
+	for i, r := range v.routes {
+		if r.Writer == nil || r.Sink != nil {
+			continue
+		}
+
+		var w *syncWriter
+		if reflect.TypeOf(r.Writer).Comparable() {
+			w = writers[r.Writer]
+		}
+		if w == nil {
+			w = &syncWriter{lock: &sync.Mutex{}, w: r.Writer}
+			if reflect.TypeOf(r.Writer).Comparable() {
+				writers[r.Writer] = w
+			}
+		}
+
+		for l := LevelInfo; l <= LevelError; l++ {
+			loggers[i][l] = newWriterLogger(w, l)
+		}
+	}
+
+	v.loggers.Store(loggers)
+}
+
+// Interface callerSink, the caller is required to limit rate of call site.
+func (v *Router) needCaller() bool {
+	for _, r := range v.routes {
+		if r.RateLimit > 0 {
+			return true
+		}
+		if s, ok := r.Sink.(callerSink); ok && s.needCaller() {
+			return true
+		}
+	}
+	return false
+}
+
+// Interface Sink, write to all matched routes.
+// @return the first error of routes.
+func (v *Router) WriteEntry(e *Entry) (err error) {
+	loggers := v.loggers.Load().([][4]Logger)
+
+	for i, r := range v.routes {
+		if r.Sink == nil && r.Writer == nil {
+			continue
+		}
+		if !r.match(e) || !r.allow(e) {
+			continue
+		}
+
+		if rerr := r.write(e, &loggers[i]); rerr != nil && err == nil {
+			err = rerr
+		}
+	}
+	return
+}
+
+// Interface io.Closer, close the writers and sinks of routes, except the stdout and stderr.
+func (v *Router) Close() (err error) {
+	closed := make(map[interface{}]bool)
+	for _, r := range v.routes {
+		for _, c := range []interface{}{r.Writer, r.Sink} {
+			// never close the stdout and stderr.
+			if c == os.Stdout || c == os.Stderr {
+				continue
+			}
+
+			closer, ok := c.(io.Closer)
+			if !ok {
+				continue
+			}
+
+			// the non-comparable closer can't be the key of map, close each one.
+			if reflect.TypeOf(c).Comparable() {
+				if closed[c] {
+					continue
+				}
+				closed[c] = true
+			}
+
+			if cerr := closer.Close(); cerr != nil && err == nil {
+				err = cerr
+			}
+		}
+	}
+	return
+}
@@ -0,0 +1,183 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2013-2016 Oryx(ossrs)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package logger
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// The log entry for sink, which keeps the level and fields.
+type Entry struct {
+	Time  time.Time
+	Level Level
+	Pid   int
+	// The cid of context, valid when HasCid.
+	Cid    int
+	HasCid bool
+	// The message without fields.
+	Message string
+	// The fields of log and context, including the trace id and parent id, nil if no field.
+	Fields Fields
+	// The id of goroutine which write the log, 0 when caller is disabled, see SetCaller.
+	Goroutine int
+
+	// the stack of caller, nil when caller and stack are disabled.
+	pcs []uintptr
+}
+
+// Create the entry of log, extract the fields from args and context, with the caller.
+func NewEntry(level Level, ctx Context, a ...interface{}) *Entry {
+	return newEntry(level, ctx, true, a)
+}
+
+// Create the entry, capture the goroutine and stack of caller when caller is true.
+func newEntry(level Level, ctx Context, caller bool, a []interface{}) *Entry {
+	args, fields := logFields(ctx, a)
+
+	e := &Entry{
+		Time:    time.Now(),
+		Level:   level,
+		Pid:     os.Getpid(),
+		Message: strings.TrimSuffix(fmt.Sprintln(args...), "\n"),
+		Fields:  fields,
+	}
+	if ctx != nil {
+		e.Cid, e.HasCid = ctx.Cid(), true
+	}
+	if caller {
+		e.Goroutine, e.pcs = goroutineID(), callers()
+	}
+
+	return e
+}
+
+// The package path of logger, to skip the frames of logger.
+var loggerPackage = reflect.TypeOf(Entry{}).PkgPath() + "."
+
+// Get the caller which call the logger, for example, the function call logger.T,
+// @remark the caller is not captured for sink unless SetCaller, SetErrorStack or the sink needs it.
+func (v *Entry) Caller() (runtime.Frame, bool) {
+	return callerFrame(v.pcs)
+}
+
+// Get the stack of caller, without the frames of logger.
+func (v *Entry) Stack() string {
+	return callerStack(v.pcs)
+}
+
+// Whether the frame is in logger package, except the tests.
+func isLoggerFrame(f runtime.Frame) bool {
+	if !strings.HasPrefix(f.Function, loggerPackage) {
+		return false
+	}
+	return !strings.HasSuffix(f.File, "_test.go")
+}
+
+// The sink to write the log entry, for example, the syslog or journald,
+// which keeps the level, rather than the io.Writer.
+type Sink interface {
+	WriteEntry(e *Entry) error
+}
+
+// The logger to write entry of level to sink.
+type loggerSink struct {
+	sink  Sink
+	level Level
+	// whether capture the caller of entry.
+	caller bool
+}
+
+func (v *loggerSink) Println(ctx Context, a ...interface{}) {
+	if _, ok := v.sink.(discardSink); ok {
+		return
+	}
+
+	if err := v.sink.WriteEntry(newEntry(v.level, ctx, v.caller, a)); err != nil {
+		fmt.Fprintln(os.Stderr, "Write log to sink failed, err is", err)
+	}
+}
+
+// The sink which use the caller of entry, for example, the Router to limit rate of call site.
+type callerSink interface {
+	// Whether the caller of entry is required.
+	needCaller() bool
+}
+
+// Whether capture the caller of entry of level for sink, with lock held.
+func sinkCaller(s Sink, l Level) bool {
+	if caller || (errorStack && l == LevelError) {
+		return true
+	}
+
+	cs, ok := s.(callerSink)
+	return ok && cs.needCaller()
+}
+
+// The sink which discard the entry.
+type discardSink struct{}
+
+func (v discardSink) WriteEntry(e *Entry) error {
+	return nil
+}
+
+// Switch the underlayer sink, all levels write to it.
+// @remark the sink is closed by Close when it's io.Closer.
+func SwitchSink(s Sink) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	sink = s
+	apply()
+
+	if s, ok := s.(io.Closer); ok {
+		previousIo = s
+	}
+}
+
+// The current sink, nil to use the writers.
+var sink Sink
+
+// Replace the sink and write all levels to it, without closing the previous io,
+// @return the function to restore the previous settings, for example, capture the logs in test.
+func ReplaceSink(s Sink) (restore func()) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	previousWriters, previousSink, previousLevel := writers, sink, level
+
+	sink, level = s, LevelInfo
+	apply()
+
+	return func() {
+		lock.Lock()
+		defer lock.Unlock()
+
+		writers, sink, level = previousWriters, previousSink, previousLevel
+		apply()
+	}
+}
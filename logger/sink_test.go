@@ -0,0 +1,410 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2013-2016 Oryx(ossrs)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package logger
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The sink which record the entries.
+type entrySink struct {
+	entries []*Entry
+}
+
+func (v *entrySink) WriteEntry(e *Entry) error {
+	v.entries = append(v.entries, e)
+	return nil
+}
+
+func TestSwitchSink(t *testing.T) {
+	defer SetLevel(GetLevel())
+	defer Close()
+
+	s := &entrySink{}
+	SwitchSink(s)
+	SetLevel(LevelWarn)
+
+	T(cid(7), "trace log")
+	W(cid(7), "warn log", Fields{"stream": "livestream"})
+	E(nil, "error log")
+
+	if len(s.entries) != 2 {
+		t.Errorf("invalid entries %v", len(s.entries))
+		return
+	}
+
+	if e := s.entries[0]; e.Level != LevelWarn || !e.HasCid || e.Cid != 7 || e.Message != "warn log" || e.Fields["stream"] != "livestream" {
+		t.Errorf("invalid entry %+v", e)
+	} else if e := s.entries[1]; e.Level != LevelError || e.HasCid || e.Pid != os.Getpid() || e.Fields != nil {
+		t.Errorf("invalid entry %+v", e)
+	}
+}
+
+func TestSyslogSink_UDP(t *testing.T) {
+	l, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed, err is %v", err)
+	}
+	defer l.Close()
+
+	s, err := NewSyslogSink("udp", l.LocalAddr().String(), "srs")
+	if err != nil {
+		t.Fatalf("create sink failed, err is %v", err)
+	}
+	defer s.Close()
+
+	e := NewEntry(LevelWarn, cid(7), "publish", Fields{"stream": `a"b]`})
+	if err := s.WriteEntry(e); err != nil {
+		t.Errorf("write failed, err is %v", err)
+	}
+
+	b := make([]byte, 4096)
+	n, _, err := l.ReadFrom(b)
+	if err != nil {
+		t.Errorf("read failed, err is %v", err)
+	}
+
+	// The daemon facility 3, warning severity 4.
+	msg := string(b[:n])
+	if !strings.HasPrefix(msg, "<28>1 ") {
+		t.Errorf("invalid pri %v", msg)
+	} else if expect := fmt.Sprintf(` srs %v - [oryx@32473 pid="%v" cid="7" stream="a\"b\]"] publish`, os.Getpid(), os.Getpid()); !strings.HasSuffix(msg, expect) {
+		t.Errorf("invalid msg %v, expect %v", msg, expect)
+	}
+
+	// Only the pid in structured data.
+	s.WriteEntry(NewEntry(LevelInfo, nil, "verbose"))
+	if n, _, err = l.ReadFrom(b); err != nil {
+		t.Errorf("read failed, err is %v", err)
+	} else if msg = string(b[:n]); !strings.HasPrefix(msg, "<31>1 ") || !strings.HasSuffix(msg, fmt.Sprintf(` - [oryx@32473 pid="%v"] verbose`, os.Getpid())) {
+		t.Errorf("invalid msg %v", msg)
+	}
+}
+
+func TestSyslogSink_RFC3164(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logger")
+	if err != nil {
+		t.Fatalf("create dir failed, err is %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "log.sock")
+	l, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("listen failed, err is %v", err)
+	}
+	defer l.Close()
+
+	// The local syslog use RFC3164.
+	s, err := NewSyslogSink("unixgram", path, "srs")
+	if err != nil {
+		t.Fatalf("create sink failed, err is %v", err)
+	}
+	defer s.Close()
+
+	if s.Format != SyslogRFC3164 {
+		t.Errorf("invalid format %v", s.Format)
+	}
+
+	e := NewEntry(LevelWarn, cid(7), "publish", Fields{"stream": "livestream"})
+	if err := s.WriteEntry(e); err != nil {
+		t.Errorf("write failed, err is %v", err)
+	}
+
+	b := make([]byte, 4096)
+	n, err := l.Read(b)
+	if err != nil {
+		t.Errorf("read failed, err is %v", err)
+	}
+
+	expect := fmt.Sprintf("<28>%v srs[%v]: [7] publish stream=livestream", e.Time.Format(time.Stamp), os.Getpid())
+	if msg := string(b[:n]); msg != expect {
+		t.Errorf("invalid msg %v, expect %v", msg, expect)
+	}
+}
+
+func TestSyslogSink_TCP(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed, err is %v", err)
+	}
+	defer l.Close()
+
+	s, err := NewSyslogSink("tcp", l.Addr().String(), "srs")
+	if err != nil {
+		t.Fatalf("create sink failed, err is %v", err)
+	}
+	defer s.Close()
+
+	c, err := l.Accept()
+	if err != nil {
+		t.Fatalf("accept failed, err is %v", err)
+	}
+	defer c.Close()
+
+	if err := s.WriteEntry(NewEntry(LevelError, nil, "failed")); err != nil {
+		t.Errorf("write failed, err is %v", err)
+	}
+
+	// The octet counting framing.
+	r := bufio.NewReader(c)
+	var size int
+	if _, err := fmt.Fscanf(r, "%d ", &size); err != nil {
+		t.Errorf("read size failed, err is %v", err)
+	}
+	b := make([]byte, size)
+	if _, err := r.Read(b); err != nil {
+		t.Errorf("read failed, err is %v", err)
+	} else if msg := string(b); !strings.HasPrefix(msg, "<27>1 ") || !strings.HasSuffix(msg, " failed") {
+		t.Errorf("invalid msg %v", msg)
+	}
+}
+
+func TestJournaldSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logger")
+	if err != nil {
+		t.Fatalf("create dir failed, err is %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "journal.sock")
+	l, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("listen failed, err is %v", err)
+	}
+	defer l.Close()
+
+	s, err := NewJournaldSink(path, "srs")
+	if err != nil {
+		t.Fatalf("create sink failed, err is %v", err)
+	}
+	defer s.Close()
+
+	e := NewEntry(LevelError, NewTraceContext("req-1", "", nil), "line0\nline1", Fields{"client-ip": "10.0.0.1", "_x": 1, "message": "hijack", "priority": 7, "1st": 2})
+	if err := s.WriteEntry(e); err != nil {
+		t.Errorf("write failed, err is %v", err)
+	}
+
+	b := make([]byte, 4096)
+	n, err := l.Read(b)
+	if err != nil {
+		t.Errorf("read failed, err is %v", err)
+	}
+
+	// The MESSAGE in binary format for newline.
+	size := make([]byte, 8)
+	binary.LittleEndian.PutUint64(size, uint64(len("line0\nline1")))
+	msg := string(b[:n])
+	for _, field := range []string{
+		"MESSAGE\n" + string(size) + "line0\nline1\n",
+		"PRIORITY=3\n",
+		"SYSLOG_IDENTIFIER=srs\n",
+		fmt.Sprintf("SYSLOG_PID=%v\n", os.Getpid()),
+		fmt.Sprintf("CID=%v\n", e.Cid),
+		"TRACE_ID=req-1\n",
+		"CLIENT_IP=10.0.0.1\n",
+		"FIELDS_X=1\n",
+		"FIELDS_MESSAGE=hijack\n",
+		"FIELDS_PRIORITY=7\n",
+		"FIELDS_1ST=2\n",
+		"CODE_FILE=",
+		"CODE_LINE=",
+		".TestJournaldSink\n",
+	} {
+		if !strings.Contains(msg, field) {
+			t.Errorf("no %q in %q", field, msg)
+		}
+	}
+
+	// The reserved fields are never duplicated.
+	for _, field := range []string{"\nMESSAGE", "\nPRIORITY=", "\nX="} {
+		if strings.Count("\n"+msg, field) > 1 {
+			t.Errorf("duplicated %q in %q", field, msg)
+		}
+	}
+}
+
+func TestSinkCaller(t *testing.T) {
+	defer SetCaller(GetCaller())
+	defer SetLevel(GetLevel())
+	defer Close()
+
+	s := &entrySink{}
+	SwitchSink(s)
+	SetLevel(LevelTrace)
+
+	// The caller is not captured when disabled, and the info is discard.
+	I(nil, "discard")
+	T(nil, "without caller")
+	if len(s.entries) != 1 {
+		t.Errorf("invalid entries %v", len(s.entries))
+	} else if _, ok := s.entries[0].Caller(); ok || s.entries[0].Goroutine != 0 {
+		t.Errorf("should not capture caller")
+	}
+
+	SetCaller(true)
+	T(nil, "with caller")
+	if len(s.entries) != 2 {
+		t.Errorf("invalid entries %v", len(s.entries))
+	} else if f, ok := s.entries[1].Caller(); !ok || !strings.HasSuffix(f.Function, "TestSinkCaller") || s.entries[1].Goroutine <= 0 {
+		t.Errorf("invalid caller %+v", f)
+	}
+}
+
+func TestRouter(t *testing.T) {
+	defer SetLevel(GetLevel())
+	defer Close()
+
+	var errors, debug bytes.Buffer
+	all := &entrySink{}
+	SwitchSink(NewRouter(
+		&Route{Levels: []Level{LevelError}, Writer: &errors},
+		&Route{Levels: []Level{LevelInfo}, Modules: []string{"rtmp"}, Cids: []int{7}, Writer: &debug},
+		&Route{Sink: all},
+		&Route{},
+	))
+	SetLevel(LevelInfo)
+
+	rtmp := NewTraceContext("", "", Fields{FieldModule: "rtmp"})
+	I(rtmp, "rtmp info")
+	I(cid(7), "http info", Fields{FieldModule: "http"})
+	I(cid(7), "rtmp info of 7", Fields{FieldModule: "rtmp"})
+	T(rtmp, "rtmp trace")
+	E(rtmp, "rtmp error")
+
+	if v := errors.String(); strings.Count(v, "\n") != 1 || !strings.Contains(v, "[error] ") || !strings.Contains(v, "rtmp error module=rtmp") {
+		t.Errorf("invalid errors %v", v)
+	} else if v := debug.String(); strings.Count(v, "\n") != 1 || !strings.Contains(v, "[7] rtmp info of 7 module=rtmp") {
+		t.Errorf("invalid debug %v", v)
+	} else if len(all.entries) != 5 {
+		t.Errorf("invalid entries %v", len(all.entries))
+	}
+}
+
+func TestRouter_Format(t *testing.T) {
+	defer SetFormat(GetFormat())
+	defer Close()
+
+	var b bytes.Buffer
+	r := NewRouter(
+		&Route{Levels: []Level{LevelError}, Writer: &b},
+		&Route{Levels: []Level{LevelTrace, LevelError}, Writer: &b},
+	)
+	SwitchSink(r)
+	SetFormat(FormatJson)
+
+	E(cid(7), "rtmp error")
+
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != 2 {
+		t.Errorf("invalid log %v", b.String())
+		return
+	}
+	for _, line := range lines {
+		var v map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &v); err != nil || v["msg"] != "rtmp error" || v["cid"] != float64(7) {
+			t.Errorf("invalid log %v, err is %v", line, err)
+		}
+	}
+
+	// The routes to the same writer share one syncWriter.
+	loggers := r.loggers.Load().([][4]Logger)
+	if w0, w1 := loggers[0][LevelError].(*loggerJson).w, loggers[1][LevelTrace].(*loggerJson).w; w0 != w1 {
+		t.Errorf("writer not shared, %v != %v", w0, w1)
+	}
+}
+
+func TestRouter_RateLimit(t *testing.T) {
+	defer Close()
+
+	s := &entrySink{}
+	r := &Route{Sink: s, RateLimit: 2}
+	SwitchSink(NewRouter(r))
+
+	// Each call site is limited.
+	for i := 0; i < 5; i++ {
+		T(nil, "noisy log", i)
+		T(nil, "another noisy log", i)
+	}
+
+	if len(s.entries) != 4 || r.Dropped() != 6 {
+		t.Errorf("invalid entries %v, dropped %v", len(s.entries), r.Dropped())
+	} else if s.entries[0].Message != "noisy log 0" || s.entries[1].Message != "another noisy log 0" || s.entries[2].Message != "noisy log 1" {
+		t.Errorf("invalid entries %v", s.entries[2].Message)
+	}
+
+	// The caller is the test function.
+	if f, ok := s.entries[0].Caller(); !ok || !strings.HasSuffix(f.Function, "TestRouter_RateLimit") || !strings.HasSuffix(f.File, "sink_test.go") {
+		t.Errorf("invalid caller %+v", f)
+	}
+
+	// Only the call sites of previous seconds are cleanup.
+	r.rates = make(map[uintptr]*routeRate)
+	e := NewEntry(LevelTrace, nil, "noisy log")
+	for i := 0; i < 2; i++ {
+		r.allow(e)
+	}
+	for pc := uintptr(1); pc <= 1024; pc++ {
+		r.rates[pc] = &routeRate{second: e.Time.Unix() - 1}
+	}
+
+	o := NewEntry(LevelTrace, nil, "other log")
+	o.Time = e.Time
+	if !r.allow(o) {
+		t.Errorf("should allow")
+	} else if r.allow(e) {
+		t.Errorf("should drop for rate limit")
+	} else if len(r.rates) != 2 {
+		t.Errorf("invalid rates %v", len(r.rates))
+	}
+}
+
+// The writer which is not comparable.
+type sliceWriter []*bytes.Buffer
+
+func (v sliceWriter) Write(p []byte) (int, error) {
+	return v[0].Write(p)
+}
+
+func (v sliceWriter) Close() error {
+	return nil
+}
+
+func TestRouter_Close(t *testing.T) {
+	w := sliceWriter{&bytes.Buffer{}}
+	r := NewRouter(&Route{Writer: w}, &Route{Writer: w}, &Route{Writer: os.Stdout})
+
+	if err := r.Close(); err != nil {
+		t.Errorf("close failed, err is %v", err)
+	}
+}
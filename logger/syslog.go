@@ -0,0 +1,250 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2013-2016 Oryx(ossrs)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package logger
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The syslog severity of level, info is debug for it's very verbose.
+var syslogSeverities = [...]int{7, 6, 4, 3}
+
+// The syslog facility, for example, the LOG_DAEMON.
+const (
+	SyslogFacilityUser   = 1
+	SyslogFacilityDaemon = 3
+	SyslogFacilityLocal0 = 16
+)
+
+// The SD-ID of structured data, the private enterprise number is for example.
+const syslogSdID = "oryx@32473"
+
+// The local syslog sockets.
+var syslogSockets = []string{"/dev/log", "/var/run/syslog", "/var/run/log"}
+
+// The format of syslog message.
+type SyslogFormat int
+
+const (
+	// The RFC5424 with structured data, for remote syslog.
+	SyslogRFC5424 SyslogFormat = iota
+	// The BSD syslog of RFC3164, for local syslog which may not support RFC5424.
+	SyslogRFC3164
+)
+
+// The sink to send log to syslog in RFC5424, for example:
+//
+//	<30>1 2017-01-01T10:00:00.000000+08:00 host srs 100 - [oryx@32473 pid="100" cid="7"] publish stream
+//
+// where the level is mapped to the severity, and the pid is the PROCID.
+// For local syslog by unix socket, the message is in RFC3164, for example:
+//
+//	<30>Jan  1 10:00:00 srs[100]: [7] publish stream k=v
+//
+// @see https://tools.ietf.org/html/rfc5424
+// @see https://tools.ietf.org/html/rfc3164
+type SyslogSink struct {
+	// The facility, default to SyslogFacilityDaemon.
+	Facility int
+	// The APP-NAME, default to the name of program.
+	Tag string
+	// The format, default to SyslogRFC3164 for unix socket, or SyslogRFC5424 for others.
+	Format SyslogFormat
+
+	network  string
+	addr     string
+	hostname string
+
+	lock *sync.Mutex
+	conn net.Conn
+}
+
+// Create the syslog sink, connect to the local syslog when network is empty,
+// or the network is udp, tcp, unix or unixgram, for example, udp 127.0.0.1:514.
+// @remark for tcp, the message is framed by octet counting of RFC6587.
+func NewSyslogSink(network, addr, tag string) (*SyslogSink, error) {
+	if tag == "" {
+		tag = filepath.Base(os.Args[0])
+	}
+
+	hostname, _ := os.Hostname()
+	if hostname == "" {
+		hostname = "-"
+	}
+
+	v := &SyslogSink{
+		Facility: SyslogFacilityDaemon,
+		Tag:      tag,
+		network:  network,
+		addr:     addr,
+		hostname: hostname,
+		lock:     &sync.Mutex{},
+	}
+
+	if err := v.connect(); err != nil {
+		return nil, err
+	}
+
+	if strings.HasPrefix(v.network, "unix") {
+		v.Format = SyslogRFC3164
+	}
+	return v, nil
+}
+
+func (v *SyslogSink) connect() (err error) {
+	if v.network != "" {
+		v.conn, err = net.Dial(v.network, v.addr)
+		return
+	}
+
+	// the local syslog, unixgram or unix socket.
+	for _, addr := range syslogSockets {
+		for _, network := range []string{"unixgram", "unix"} {
+			if v.conn, err = net.Dial(network, addr); err == nil {
+				v.network, v.addr = network, addr
+				return
+			}
+		}
+	}
+	return fmt.Errorf("no local syslog, err is %v", err)
+}
+
+// Format the entry in RFC5424 or RFC3164.
+func (v *SyslogSink) format(e *Entry) []byte {
+	if v.Format == SyslogRFC3164 {
+		return v.formatRFC3164(e)
+	}
+
+	var b bytes.Buffer
+
+	pri := v.Facility*8 + syslogSeverities[e.Level]
+	fmt.Fprintf(&b, "<%v>1 %v %v %v %v - ", pri, e.Time.Format("2006-01-02T15:04:05.000000Z07:00"),
+		v.hostname, syslogName(v.Tag, 48), e.Pid)
+
+	b.WriteString("[" + syslogSdID)
+	fmt.Fprintf(&b, ` pid="%v"`, e.Pid)
+	if e.HasCid {
+		fmt.Fprintf(&b, ` cid="%v"`, e.Cid)
+	}
+	for _, k := range e.Fields.keys() {
+		fmt.Fprintf(&b, ` %v="%v"`, syslogName(k, 32), syslogEscaper.Replace(fmt.Sprint(e.Fields[k])))
+	}
+	b.WriteString("]")
+
+	b.WriteString(" ")
+	b.WriteString(e.Message)
+
+	return b.Bytes()
+}
+
+// Format the entry in RFC3164, without hostname for local syslog,
+// the cid and fields are in message like the text log.
+func (v *SyslogSink) formatRFC3164(e *Entry) []byte {
+	var b bytes.Buffer
+
+	pri := v.Facility*8 + syslogSeverities[e.Level]
+	fmt.Fprintf(&b, "<%v>%v %v[%v]: ", pri, e.Time.Format(time.Stamp), syslogName(v.Tag, 32), e.Pid)
+
+	if e.HasCid {
+		fmt.Fprintf(&b, "[%v] ", e.Cid)
+	}
+	b.WriteString(e.Message)
+	if len(e.Fields) > 0 {
+		b.WriteString(" ")
+		b.WriteString(e.Fields.String())
+	}
+
+	return b.Bytes()
+}
+
+// The escaper for PARAM-VALUE of structured data.
+var syslogEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+// Convert to the printable name without space, =, ] and ", at most n chars.
+func syslogName(s string, n int) string {
+	b := []byte(s)
+	for i, c := range b {
+		if c <= 32 || c >= 127 || c == '=' || c == ']' || c == '"' {
+			b[i] = '_'
+		}
+	}
+	if len(b) > n {
+		b = b[:n]
+	}
+	if len(b) == 0 {
+		return "-"
+	}
+	return string(b)
+}
+
+// Interface Sink, reconnect and retry once when failed.
+func (v *SyslogSink) WriteEntry(e *Entry) (err error) {
+	msg := v.format(e)
+
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	if v.conn != nil {
+		if _, err = v.conn.Write(v.frame(msg)); err == nil {
+			return
+		}
+		v.conn.Close()
+		v.conn = nil
+	}
+
+	// the network maybe changed when connect to local syslog.
+	if err = v.connect(); err != nil {
+		return
+	}
+	_, err = v.conn.Write(v.frame(msg))
+	return
+}
+
+// Frame the message for stream network, with lock held.
+func (v *SyslogSink) frame(msg []byte) []byte {
+	switch v.network {
+	case "tcp", "tcp4", "tcp6":
+		return append([]byte(fmt.Sprintf("%v ", len(msg))), msg...)
+	case "unix":
+		return append(msg[:len(msg):len(msg)], '\n')
+	}
+	return msg
+}
+
+// Interface io.Closer.
+func (v *SyslogSink) Close() (err error) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	if v.conn != nil {
+		err = v.conn.Close()
+		v.conn = nil
+	}
+	return
+}
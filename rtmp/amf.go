@@ -274,12 +274,18 @@ var AMF0_END_OBJECT_MARKER = []byte{0x00, 0x00, 0x09}
 type AMF0Object struct {
 	AMF0Item
 	Properties map[string]*AMF0String
+	// the strict array properties, for example, the fourCcList in connect.
+	Arrays map[string]*AMF0StrictArray
 }
 
 func (v *AMF0Object) Write(propertyKey []byte, propertyValue *AMF0String) {
 	v.Properties[string(propertyKey[:])] = propertyValue
 }
 
+func (v *AMF0Object) WriteStrictArray(propertyKey []byte, propertyValue *AMF0StrictArray) {
+	v.Arrays[string(propertyKey[:])] = propertyValue
+}
+
 func (v *AMF0Object) Dumps() []byte {
 	var buf bytes.Buffer
 	buf.Write([]byte{v.Marker})
@@ -291,20 +297,28 @@ func (v *AMF0Object) Dumps() []byte {
 
 		buf.Write(value.Dumps())
 	}
+	for key, value := range v.Arrays {
+		tmp := make([]byte, 2)
+		binary.BigEndian.PutUint16(tmp, uint16(len(key)))
+		buf.Write(tmp)
+		buf.Write([]byte(key))
+
+		buf.Write(value.Dumps())
+	}
 
 	buf.Write(AMF0_END_OBJECT_MARKER)
 	return buf.Bytes()
 }
 
 func NewAMF0Object() (*AMF0Object, error) {
-	it := &AMF0Object{}
+	it := &AMF0Object{Properties: make(map[string]*AMF0String), Arrays: make(map[string]*AMF0StrictArray)}
 	it.Marker = OBJECT_MARKER
 
 	return it, nil
 }
 
 func ParseAMF0Object(reader io.Reader) (*AMF0Object, error) {
-	it := &AMF0Object{}
+	it := &AMF0Object{Properties: make(map[string]*AMF0String), Arrays: make(map[string]*AMF0StrictArray)}
 	var buf bytes.Buffer
 	it.Marker = OBJECT_MARKER
 
@@ -349,8 +363,19 @@ func ParseAMF0Object(reader io.Reader) (*AMF0Object, error) {
 		}
 		buf.Write(marker)
 
+		// strict array property, for example, the fourCcList in connect.
+		if marker[0] == STRICT_ARRAY_MARKER {
+			arr, err := ParseAmf0StrictArray(reader)
+			if err != nil {
+				return nil, err
+			}
+			it.Arrays[string(name[:])] = arr
+			buf.Write(arr.Payload)
+			continue
+		}
+
 		if marker[0] != STRING_MARKER {
-			err := fmt.Errorf("property for AMF0 object should be utf-8")
+			err := fmt.Errorf("property for AMF0 object should be utf-8 or strict array")
 			return nil, err
 		}
 
@@ -480,9 +505,10 @@ func (v *AMF0StrictArray) Dumps() []byte {
 
 	count := make([]byte, 4)
 	binary.BigEndian.PutUint32(count, uint32(v.ArrayList.Len()))
+	buf.Write(count)
 
 	for i := v.ArrayList.Front(); i != nil; i = i.Next() {
-		if array, ok := i.Value.(AMF0Item); ok {
+		if array, ok := i.Value.(IAMF0Item); ok {
 			buf.Write(array.Dumps())
 		}
 	}
@@ -0,0 +1,299 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2013-2016 Oryx(ossrs)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package rtmp
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	oh "github.com/SnailTowardThesun/go-oryx-lib/http"
+	ol "github.com/SnailTowardThesun/go-oryx-lib/logger"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The action to authorize, when server accepts connect/publish/play.
+const (
+	RTMP_AUTH_ACTION_CONNECT = "connect"
+	RTMP_AUTH_ACTION_PUBLISH = "publish"
+	RTMP_AUTH_ACTION_PLAY    = "play"
+)
+
+// The onStatus code when deny the action.
+const (
+	RTMP_STATUS_CONNECT_REJECTED = "NetConnection.Connect.Rejected"
+	RTMP_STATUS_PUBLISH_BADNAME  = "NetStream.Publish.BadName"
+	RTMP_STATUS_PLAY_FAILED      = "NetStream.Play.Failed"
+)
+
+// The request to authorize.
+type RtmpAuthRequest struct {
+	// the action, for example, RTMP_AUTH_ACTION_PUBLISH.
+	Action string
+	// the tcUrl in connect, for example, rtmp://host/app?token=xxx
+	TcUrl string
+	// the app, for example, live.
+	App string
+	// the stream name without query, empty for connect.
+	Stream string
+	// the query of tcUrl and stream, the stream query override the tcUrl query.
+	Query url.Values
+	// the address of client.
+	PeerAddr string
+}
+
+// Create the request from tcUrl and stream which maybe with query.
+// @remark the peer is optional, nil to ignore.
+func NewRtmpAuthRequest(action, tcUrl, stream string, peer net.Addr) (*RtmpAuthRequest, error) {
+	u, err := url.Parse(tcUrl)
+	if err != nil {
+		return nil, fmt.Errorf("parse tcUrl %v failed, err is %v", tcUrl, err)
+	}
+
+	v := &RtmpAuthRequest{
+		Action: action,
+		TcUrl:  tcUrl,
+		App:    strings.Trim(u.Path, "/"),
+		Query:  u.Query(),
+	}
+
+	if peer != nil {
+		v.PeerAddr = peer.String()
+	}
+
+	v.Stream = stream
+	if pos := strings.Index(stream, "?"); pos >= 0 {
+		v.Stream = stream[:pos]
+
+		q, err := url.ParseQuery(stream[pos+1:])
+		if err != nil {
+			return nil, fmt.Errorf("parse stream %v query failed, err is %v", stream, err)
+		}
+		for k, values := range q {
+			v.Query[k] = values
+		}
+	}
+
+	return v, nil
+}
+
+func (v *RtmpAuthRequest) String() string {
+	return fmt.Sprintf("action=%v, tcUrl=%v, app=%v, stream=%v, peer=%v", v.Action, v.TcUrl, v.App, v.Stream, v.PeerAddr)
+}
+
+// The error when deny the request, which is sent to client in onStatus.
+type RtmpAuthError struct {
+	// the onStatus code, for example, RTMP_STATUS_PUBLISH_BADNAME.
+	Code string
+	// the description for this error.
+	Description string
+}
+
+func (v *RtmpAuthError) Error() string {
+	return fmt.Sprintf("%v, %v", v.Code, v.Description)
+}
+
+// Create the onStatus object {level, code, description} for this error.
+func (v *RtmpAuthError) OnStatus() (*AMF0Object, error) {
+	obj, err := NewAMF0Object()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, p := range [][2]string{{"level", "error"}, {"code", v.Code}, {"description", v.Description}} {
+		str, err := NewAMF0String([]byte(p[1]))
+		if err != nil {
+			return nil, err
+		}
+		obj.Write([]byte(p[0]), str)
+	}
+
+	return obj, nil
+}
+
+// Create the error to deny the action, use the default onStatus code of action.
+func NewRtmpAuthDenied(action, description string) *RtmpAuthError {
+	code := RTMP_STATUS_CONNECT_REJECTED
+	if action == RTMP_AUTH_ACTION_PUBLISH {
+		code = RTMP_STATUS_PUBLISH_BADNAME
+	} else if action == RTMP_AUTH_ACTION_PLAY {
+		code = RTMP_STATUS_PLAY_FAILED
+	}
+
+	return &RtmpAuthError{Code: code, Description: description}
+}
+
+// The authorizer for server to check the connect/publish/play.
+type RtmpAuthorizer interface {
+	// Authorize the request, return nil to allow, or RtmpAuthError to deny.
+	// @remark server should deny with NewRtmpAuthDenied for other errors.
+	Authorize(ctx ol.Context, req *RtmpAuthRequest) error
+}
+
+// The authorizers which allow only when all authorizers allow.
+type RtmpAuthorizers []RtmpAuthorizer
+
+func (v RtmpAuthorizers) Authorize(ctx ol.Context, req *RtmpAuthRequest) error {
+	for _, a := range v {
+		if err := a.Authorize(ctx, req); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// The query to sign the url by RtmpHmacAuthorizer.
+const (
+	RTMP_AUTH_QUERY_TOKEN  = "token"
+	RTMP_AUTH_QUERY_EXPIRE = "expire"
+)
+
+// Sign the stream for action which expire at the time, the token is
+//
+//	hex(hmac-sha256(secret, action + app + stream + unix-seconds))
+//
+// where each component is prefixed by its 4B big-endian length, so it's unambiguous
+// even when the app or stream contains "/", and the token for play can't be used to publish.
+// User should append the token and expire to the query of stream, for example:
+//
+//	rtmp://host/app/stream?token=xxx&expire=1477994400
+func RtmpHmacSign(secret []byte, action, app, stream string, expire time.Time) string {
+	h := hmac.New(sha256.New, secret)
+
+	tmp := make([]byte, 4)
+	for _, c := range []string{action, app, stream, strconv.FormatInt(expire.Unix(), 10)} {
+		binary.BigEndian.PutUint32(tmp, uint32(len(c)))
+		h.Write(tmp)
+		h.Write([]byte(c))
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// The authorizer for publish and play with HMAC-signed url token,
+// see RtmpHmacSign, the connect is always allowed for there is no stream.
+type RtmpHmacAuthorizer struct {
+	// the secret to sign the url.
+	Secret []byte
+}
+
+func NewRtmpHmacAuthorizer(secret []byte) *RtmpHmacAuthorizer {
+	return &RtmpHmacAuthorizer{Secret: secret}
+}
+
+func (v *RtmpHmacAuthorizer) Authorize(ctx ol.Context, req *RtmpAuthRequest) error {
+	if req.Action == RTMP_AUTH_ACTION_CONNECT {
+		return nil
+	}
+
+	token, expire := req.Query.Get(RTMP_AUTH_QUERY_TOKEN), req.Query.Get(RTMP_AUTH_QUERY_EXPIRE)
+	if token == "" || expire == "" {
+		ol.W(ctx, "auth deny for no token,", req)
+		return NewRtmpAuthDenied(req.Action, "no token")
+	}
+
+	ts, err := strconv.ParseInt(expire, 10, 64)
+	if err != nil {
+		ol.W(ctx, "auth deny for invalid expire", expire, ",", req)
+		return NewRtmpAuthDenied(req.Action, "invalid expire")
+	}
+
+	if time.Now().Unix() > ts {
+		ol.W(ctx, "auth deny for token expired at", ts, ",", req)
+		return NewRtmpAuthDenied(req.Action, "token expired")
+	}
+
+	expect := RtmpHmacSign(v.Secret, req.Action, req.App, req.Stream, time.Unix(ts, 0))
+	if !hmac.Equal([]byte(token), []byte(expect)) {
+		ol.W(ctx, "auth deny for invalid token,", req)
+		return NewRtmpAuthDenied(req.Action, "invalid token")
+	}
+
+	return nil
+}
+
+// The authorizer by HTTP callback, POST the request in json to url, for example:
+//
+//	{"action":"on_publish", "client_addr":"x", "tcUrl":"x", "app":"x", "stream":"x", "param":"?x"}
+//
+// and the server response the standard {code, data}, where code 0 to allow, others to deny.
+type RtmpHttpAuthorizer struct {
+	// the callback url for each action, for example, on_publish for RTMP_AUTH_ACTION_PUBLISH,
+	// the action without url is allowed.
+	Urls map[string]string
+	// the timeout of callback, the request is denied when timeout,
+	// use RTMP_AUTH_HTTP_TIMEOUT when not set.
+	Timeout time.Duration
+}
+
+// The default timeout of HTTP callback.
+const RTMP_AUTH_HTTP_TIMEOUT = 5 * time.Second
+
+// Create the authorizer which callback the url for all actions.
+func NewRtmpHttpAuthorizer(url string) *RtmpHttpAuthorizer {
+	return &RtmpHttpAuthorizer{
+		Urls: map[string]string{
+			RTMP_AUTH_ACTION_CONNECT: url,
+			RTMP_AUTH_ACTION_PUBLISH: url,
+			RTMP_AUTH_ACTION_PLAY:    url,
+		},
+		Timeout: RTMP_AUTH_HTTP_TIMEOUT,
+	}
+}
+
+func (v *RtmpHttpAuthorizer) Authorize(ctx ol.Context, req *RtmpAuthRequest) error {
+	url, ok := v.Urls[req.Action]
+	if !ok || url == "" {
+		return nil
+	}
+
+	var param string
+	if len(req.Query) > 0 {
+		param = "?" + req.Query.Encode()
+	}
+
+	timeout := v.Timeout
+	if timeout <= 0 {
+		timeout = RTMP_AUTH_HTTP_TIMEOUT
+	}
+
+	code, _, err := oh.ApiPostClient(&http.Client{Timeout: timeout}, url, map[string]interface{}{
+		"action":      "on_" + req.Action,
+		"client_addr": req.PeerAddr,
+		"tcUrl":       req.TcUrl,
+		"app":         req.App,
+		"stream":      req.Stream,
+		"param":       param,
+	})
+	if err != nil {
+		ol.W(ctx, "auth deny by", url, "code", code, ",", req, "err is", err)
+		return NewRtmpAuthDenied(req.Action, fmt.Sprintf("callback code=%v", code))
+	}
+
+	return nil
+}
@@ -0,0 +1,153 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2013-2016 Oryx(ossrs)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package rtmp_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/SnailTowardThesun/go-oryx-lib/rtmp"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNewRtmpAuthRequest(t *testing.T) {
+	req, err := rtmp.NewRtmpAuthRequest(rtmp.RTMP_AUTH_ACTION_PUBLISH, "rtmp://127.0.0.1/live?vhost=a", "stream?token=xxx", nil)
+	if err != nil {
+		t.Error("create auth request failed. err is", err)
+		return
+	}
+
+	if req.App != "live" || req.Stream != "stream" || req.Query.Get("vhost") != "a" || req.Query.Get("token") != "xxx" {
+		t.Errorf("invalid request %v, query %v", req, req.Query)
+		return
+	}
+}
+
+func TestRtmpHmacAuthorizer(t *testing.T) {
+	secret := []byte("secret")
+	a := rtmp.NewRtmpHmacAuthorizer(secret)
+
+	expire := time.Now().Add(time.Hour)
+	token := rtmp.RtmpHmacSign(secret, rtmp.RTMP_AUTH_ACTION_PLAY, "live", "stream", expire)
+
+	stream := fmt.Sprintf("stream?token=%v&expire=%v", token, expire.Unix())
+	req, _ := rtmp.NewRtmpAuthRequest(rtmp.RTMP_AUTH_ACTION_PLAY, "rtmp://127.0.0.1/live", stream, nil)
+	if err := a.Authorize(nil, req); err != nil {
+		t.Error("should allow. err is", err)
+		return
+	}
+
+	// Signed for another stream.
+	stream = fmt.Sprintf("other?token=%v&expire=%v", token, expire.Unix())
+	req, _ = rtmp.NewRtmpAuthRequest(rtmp.RTMP_AUTH_ACTION_PLAY, "rtmp://127.0.0.1/live", stream, nil)
+	if err, ok := a.Authorize(nil, req).(*rtmp.RtmpAuthError); !ok || err.Code != rtmp.RTMP_STATUS_PLAY_FAILED {
+		t.Error("should deny for invalid token, err is", err)
+		return
+	}
+
+	// Signed for play, but used to publish.
+	stream = fmt.Sprintf("stream?token=%v&expire=%v", token, expire.Unix())
+	req, _ = rtmp.NewRtmpAuthRequest(rtmp.RTMP_AUTH_ACTION_PUBLISH, "rtmp://127.0.0.1/live", stream, nil)
+	if err, ok := a.Authorize(nil, req).(*rtmp.RtmpAuthError); !ok || err.Code != rtmp.RTMP_STATUS_PUBLISH_BADNAME {
+		t.Error("should deny for token of play, err is", err)
+		return
+	}
+
+	// The app and stream with "/" should be signed differently.
+	if rtmp.RtmpHmacSign(secret, rtmp.RTMP_AUTH_ACTION_PLAY, "live/a", "b", expire) == rtmp.RtmpHmacSign(secret, rtmp.RTMP_AUTH_ACTION_PLAY, "live", "a/b", expire) {
+		t.Error("should sign differently for app live/a and live")
+		return
+	}
+
+	// Expired token.
+	expire = time.Now().Add(-time.Hour)
+	token = rtmp.RtmpHmacSign(secret, rtmp.RTMP_AUTH_ACTION_PUBLISH, "live", "stream", expire)
+	stream = fmt.Sprintf("stream?token=%v&expire=%v", token, expire.Unix())
+	req, _ = rtmp.NewRtmpAuthRequest(rtmp.RTMP_AUTH_ACTION_PUBLISH, "rtmp://127.0.0.1/live", stream, nil)
+	if err, ok := a.Authorize(nil, req).(*rtmp.RtmpAuthError); !ok || err.Code != rtmp.RTMP_STATUS_PUBLISH_BADNAME {
+		t.Error("should deny for expired token, err is", err)
+		return
+	}
+}
+
+func TestRtmpHttpAuthorizer(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&req)
+
+		if req["action"] == "on_publish" && req["stream"] == "allow" {
+			w.Write([]byte(`{"code":0}`))
+		} else {
+			w.Write([]byte(`{"code":403}`))
+		}
+	}))
+	defer server.Close()
+
+	a := rtmp.NewRtmpHttpAuthorizer(server.URL)
+
+	req, _ := rtmp.NewRtmpAuthRequest(rtmp.RTMP_AUTH_ACTION_PUBLISH, "rtmp://127.0.0.1/live", "allow", nil)
+	if err := a.Authorize(nil, req); err != nil {
+		t.Error("should allow. err is", err)
+		return
+	}
+
+	req, _ = rtmp.NewRtmpAuthRequest(rtmp.RTMP_AUTH_ACTION_PUBLISH, "rtmp://127.0.0.1/live", "deny", nil)
+	err, ok := a.Authorize(nil, req).(*rtmp.RtmpAuthError)
+	if !ok || err.Code != rtmp.RTMP_STATUS_PUBLISH_BADNAME {
+		t.Error("should deny, err is", err)
+		return
+	}
+
+	if obj, err := err.OnStatus(); err != nil {
+		t.Error("create onStatus failed. err is", err)
+		return
+	} else if string(obj.Properties["code"].Bytes) != rtmp.RTMP_STATUS_PUBLISH_BADNAME {
+		t.Error("invalid onStatus code", string(obj.Properties["code"].Bytes))
+		return
+	}
+}
+
+func TestRtmpHttpAuthorizer_Timeout(t *testing.T) {
+	done := make(chan bool)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	}))
+	defer server.Close()
+	defer close(done)
+
+	a := rtmp.NewRtmpHttpAuthorizer(server.URL)
+	a.Timeout = 100 * time.Millisecond
+
+	req, _ := rtmp.NewRtmpAuthRequest(rtmp.RTMP_AUTH_ACTION_PUBLISH, "rtmp://127.0.0.1/live", "stream", nil)
+
+	starttime := time.Now()
+	if err, ok := a.Authorize(nil, req).(*rtmp.RtmpAuthError); !ok || err.Code != rtmp.RTMP_STATUS_PUBLISH_BADNAME {
+		t.Error("should deny when timeout, err is", err)
+		return
+	}
+
+	if d := time.Since(starttime); d > 3*time.Second {
+		t.Errorf("callback should timeout, elapsed %v", d)
+	}
+}
@@ -302,6 +302,74 @@ func TestRtmpChunkMessage_Read(t *testing.T) {
 	}
 }
 
+func TestRtmpChunkMessage_ExtendedTimestamp(t *testing.T) {
+	rd := rtmp.RtmpRandomData(256)
+
+	list, err := rtmp.ChunkMessage(rd, 128, 4, 9, 1234)
+	if err != nil {
+		t.Error("create chunk message failed. err is", err)
+		return
+	}
+
+	list[0].Timestamp = 0x12345678
+	list[0].GenerateMsgHeader()
+	if list[0].ExtendTimeStamp != 0x12345678 {
+		t.Errorf("extended timestamp=%x is invalid", list[0].ExtendTimeStamp)
+		return
+	}
+
+	var w bytes.Buffer
+	if _, err := rtmp.WriteChunkMessages(&w, list); err != nil {
+		t.Error("write chunks failed. err is", err)
+		return
+	}
+
+	// Reuse the chunk for the fmt3 chunk, which has extended timestamp too.
+	msg := &rtmp.RtmpChunkMessage{}
+	if err := msg.Read(&w, 128); err != nil {
+		t.Error("chunk message read failed. err is", err)
+		return
+	} else if msg.Formt != 0 || msg.Timestamp != 0x12345678 {
+		t.Errorf("format=%v, timestamp=%x is invalid", msg.Formt, msg.Timestamp)
+		return
+	}
+
+	if err := msg.Read(&w, 128); err != nil {
+		t.Error("chunk message read failed. err is", err)
+		return
+	} else if msg.Formt != 3 || msg.ExtendTimeStamp != 0x12345678 || !bytes.Equal(msg.Data, rd[128:]) {
+		t.Errorf("format=%v, extended timestamp=%x is invalid", msg.Formt, msg.ExtendTimeStamp)
+		return
+	}
+
+	// Re-time to a small timestamp, neither the first nor the fmt3 chunk has extended timestamp.
+	list[0].Timestamp = 1000
+	list[0].GenerateMsgHeader()
+
+	w.Reset()
+	if _, err := rtmp.WriteChunkMessages(&w, list); err != nil {
+		t.Error("write chunks failed. err is", err)
+		return
+	} else if w.Len() != 1+11+128+1+128 {
+		t.Errorf("size=%v of chunks is invalid", w.Len())
+		return
+	}
+
+	msg = &rtmp.RtmpChunkMessage{}
+	if err := msg.Read(&w, 128); err != nil || msg.Timestamp != 1000 {
+		t.Errorf("read chunk failed, timestamp=%v, err is %v", msg.Timestamp, err)
+		return
+	}
+
+	if err := msg.Read(&w, 128); err != nil {
+		t.Error("chunk message read failed. err is", err)
+		return
+	} else if msg.Formt != 3 || msg.ExtendTimeStamp != 0 || !bytes.Equal(msg.Data, rd[128:]) {
+		t.Errorf("format=%v, extended timestamp=%x is invalid", msg.Formt, msg.ExtendTimeStamp)
+		return
+	}
+}
+
 func TestNewRtmpMsgSetChunkSize(t *testing.T) {
 	chunkSize := uint32(512)
 	StreamID := uint32(1024)
@@ -0,0 +1,167 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2013-2016 Oryx(ossrs)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package rtmp
+
+import "io"
+
+// The algorithm to correct the timestamp of messages, like SRS mix_correct.
+type RtmpJitterAlgorithm int
+
+const (
+	// Use full algorithm, to ensure the stream start at zero, and the delta is
+	// corrected when jitter exceed RtmpMaxJitter, the audio and video are monotonic.
+	RTMP_JITTER_FULL RtmpJitterAlgorithm = iota
+	// Only ensure the stream start at zero, ignore the jitter.
+	RTMP_JITTER_ZERO
+	// Disable the jitter correction, use the timestamp of encoder.
+	RTMP_JITTER_OFF
+)
+
+func (v RtmpJitterAlgorithm) String() string {
+	switch v {
+	case RTMP_JITTER_FULL:
+		return "full"
+	case RTMP_JITTER_ZERO:
+		return "zero"
+	default:
+		return "off"
+	}
+}
+
+// The max jitter in ms, the delta exceed it is corrected to RtmpDefaultFrameTime.
+const RtmpMaxJitter = 250
+
+// The default frame time in ms, for the corrected delta.
+const RtmpDefaultFrameTime = 10
+
+// The jitter corrector for a stream, which correct the timestamp of each message
+// before messages reach muxers or subscribers.
+// @remark the 32bits timestamp wraparound is handled, the corrected timestamp is 64bits.
+type RtmpJitter struct {
+	algorithm RtmpJitterAlgorithm
+
+	// whether the first message is corrected.
+	initialized bool
+	// the previous timestamp of encoder.
+	lastTime uint32
+	// the corrected timestamp of previous message.
+	lastCorrect int64
+	// the corrected timestamp of previous audio and video, to keep them monotonic.
+	lastAudio int64
+	lastVideo int64
+}
+
+func NewRtmpJitter(algorithm RtmpJitterAlgorithm) *RtmpJitter {
+	return &RtmpJitter{algorithm: algorithm}
+}
+
+func (v *RtmpJitter) Algorithm() RtmpJitterAlgorithm {
+	return v.algorithm
+}
+
+// Correct the timestamp of msg, update the msg.Timestamp to the low 32bits of
+// the corrected timestamp and return the 64bits corrected timestamp in ms.
+func (v *RtmpJitter) Correct(msg *RtmpMessage) int64 {
+	time := v.correct(msg.MessageType, msg.Timestamp)
+	msg.Timestamp = uint32(time)
+	return time
+}
+
+func (v *RtmpJitter) correct(msgType uint8, ts uint32) int64 {
+	if v.algorithm == RTMP_JITTER_OFF {
+		return int64(ts)
+	}
+
+	if !v.initialized {
+		v.initialized = true
+		v.lastTime = ts
+		v.lastCorrect, v.lastAudio, v.lastVideo = 0, 0, 0
+		return 0
+	}
+
+	// the delta in int32 to handle the 32bits wraparound,
+	// for example, from 0xffffff80 to 0x00000010 is 0x90.
+	delta := int64(int32(ts - v.lastTime))
+
+	// for zero, the lastCorrect is the delta to the first message, which maybe negative
+	// when messages are out of order, so clamp the corrected timestamp at 0.
+	if v.algorithm == RTMP_JITTER_ZERO {
+		v.lastTime = ts
+		if v.lastCorrect += delta; v.lastCorrect < 0 {
+			return 0
+		}
+		return v.lastCorrect
+	}
+
+	// for metadata and other messages, use the time of previous message.
+	isAudio, isVideo := msgType == RTMP_COMMANDS_MSG_AUDIO, msgType == RTMP_COMMANDS_MSG_VIDEO
+	if !isAudio && !isVideo {
+		return v.lastCorrect
+	}
+	v.lastTime = ts
+
+	if delta < -RtmpMaxJitter || delta > RtmpMaxJitter {
+		delta = RtmpDefaultFrameTime
+	}
+
+	if v.lastCorrect += delta; v.lastCorrect < 0 {
+		v.lastCorrect = 0
+	}
+
+	// keep the audio and video monotonic.
+	time := v.lastCorrect
+	if isAudio {
+		if time < v.lastAudio {
+			time = v.lastAudio
+		}
+		v.lastAudio = time
+	} else {
+		if time < v.lastVideo {
+			time = v.lastVideo
+		}
+		v.lastVideo = time
+	}
+
+	return time
+}
+
+// The reader which read messages and correct the timestamp by jitter.
+type RtmpJitterReader struct {
+	reader io.Reader
+	jitter *RtmpJitter
+}
+
+func NewRtmpJitterReader(reader io.Reader, algorithm RtmpJitterAlgorithm) *RtmpJitterReader {
+	return &RtmpJitterReader{
+		reader: reader,
+		jitter: NewRtmpJitter(algorithm),
+	}
+}
+
+// Read the message like ReadRtmpMessage, and correct its timestamp,
+// @return the 64bits corrected timestamp in ms.
+func (v *RtmpJitterReader) Read(msg *RtmpMessage) (int64, error) {
+	if err := ReadRtmpMessage(v.reader, msg); err != nil {
+		return 0, err
+	}
+	return v.jitter.Correct(msg), nil
+}
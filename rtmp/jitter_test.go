@@ -0,0 +1,115 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2013-2016 Oryx(ossrs)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package rtmp_test
+
+import (
+	"github.com/SnailTowardThesun/go-oryx-lib/rtmp"
+	"testing"
+)
+
+func correctJitter(j *rtmp.RtmpJitter, msgType uint8, ts uint32) int64 {
+	msg := &rtmp.RtmpMessage{MessageType: msgType, Timestamp: ts}
+	return j.Correct(msg)
+}
+
+func TestRtmpJitter_Full(t *testing.T) {
+	j := rtmp.NewRtmpJitter(rtmp.RTMP_JITTER_FULL)
+
+	video, audio := uint8(rtmp.RTMP_COMMANDS_MSG_VIDEO), uint8(rtmp.RTMP_COMMANDS_MSG_AUDIO)
+	cases := []struct {
+		msgType uint8
+		ts      uint32
+		expect  int64
+	}{
+		// start at zero.
+		{video, 1000, 0},
+		{audio, 1020, 20},
+		{video, 1040, 40},
+		// jump is corrected to default frame time.
+		{video, 90000, 40 + rtmp.RtmpDefaultFrameTime},
+		{audio, 90020, 70},
+		// small jitter backward, the audio keep monotonic.
+		{audio, 90000, 70},
+		{video, 90040, 90},
+		// metadata use the time of previous message.
+		{rtmp.RTMP_COMMANDS_MSG_DATA_AMF0, 12345, 90},
+		{video, 90080, 130},
+	}
+
+	for i, c := range cases {
+		if v := correctJitter(j, c.msgType, c.ts); v != c.expect {
+			t.Errorf("case #%v ts=%v corrected to %v, should be %v", i, c.ts, v, c.expect)
+			return
+		}
+	}
+}
+
+func TestRtmpJitter_Zero(t *testing.T) {
+	j := rtmp.NewRtmpJitter(rtmp.RTMP_JITTER_ZERO)
+
+	video, audio := uint8(rtmp.RTMP_COMMANDS_MSG_VIDEO), uint8(rtmp.RTMP_COMMANDS_MSG_AUDIO)
+	cases := []struct {
+		msgType uint8
+		ts      uint32
+		expect  int64
+	}{
+		// start at zero, the audio before first video is clamped at zero.
+		{video, 100, 0},
+		{audio, 90, 0},
+		// interleaved and out of order, the delta to first message is kept.
+		{video, 133, 33},
+		{audio, 113, 13},
+		{audio, 136, 36},
+		{video, 166, 66},
+		{audio, 80, 0},
+		{video, 200, 100},
+	}
+
+	for i, c := range cases {
+		if v := correctJitter(j, c.msgType, c.ts); v != c.expect {
+			t.Errorf("case #%v ts=%v corrected to %v, should be %v", i, c.ts, v, c.expect)
+			return
+		}
+	}
+}
+
+func TestRtmpJitter_Wraparound(t *testing.T) {
+	for _, ag := range []rtmp.RtmpJitterAlgorithm{rtmp.RTMP_JITTER_FULL, rtmp.RTMP_JITTER_ZERO} {
+		j := rtmp.NewRtmpJitter(ag)
+
+		if v := correctJitter(j, rtmp.RTMP_COMMANDS_MSG_VIDEO, 0xffffff80); v != 0 {
+			t.Errorf("%v corrected to %v, should be 0", ag, v)
+			return
+		}
+
+		if v := correctJitter(j, rtmp.RTMP_COMMANDS_MSG_VIDEO, 0x00000010); v != 0x90 {
+			t.Errorf("%v corrected to %v, should be %v", ag, v, 0x90)
+			return
+		}
+	}
+
+	j := rtmp.NewRtmpJitter(rtmp.RTMP_JITTER_OFF)
+	if v := correctJitter(j, rtmp.RTMP_COMMANDS_MSG_VIDEO, 0xffffff00); v != 0xffffff00 {
+		t.Errorf("off corrected to %v", v)
+		return
+	}
+}
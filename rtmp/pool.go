@@ -0,0 +1,77 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2013-2016 Oryx(ossrs)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package rtmp
+
+import "sync"
+
+// The pool of messages, which keep the payload buffer to reuse,
+// for server with thousands of streams, to reduce the GC pressure.
+var rtmpMessagePool = sync.Pool{
+	New: func() interface{} {
+		return &RtmpMessage{}
+	},
+}
+
+// The max payload capacity of message to put back to pool,
+// the larger message is dropped to avoid the pool to hold too much memory.
+const RtmpMessagePoolMaxPayload = 1024 * 1024
+
+// Get a message from pool, the PayLoad maybe reused from previous message.
+// @remark user should use ReleaseRtmpMessage to put it back when never use it.
+func AcquireRtmpMessage() *RtmpMessage {
+	return rtmpMessagePool.Get().(*RtmpMessage)
+}
+
+// Put the message back to pool, user should never use the msg and its PayLoad again.
+func ReleaseRtmpMessage(msg *RtmpMessage) {
+	if msg == nil || cap(msg.PayLoad) > RtmpMessagePoolMaxPayload {
+		return
+	}
+
+	msg.MessageType = 0
+	msg.PayloadLength = 0
+	msg.Timestamp = 0
+	msg.StreamID = 0
+	msg.PayLoad = msg.PayLoad[:0]
+
+	rtmpMessagePool.Put(msg)
+}
+
+// Resize the b to size, reuse the b when its capacity is enough.
+func growBuffer(b []byte, size int) []byte {
+	if cap(b) >= size {
+		return b[:size]
+	}
+	return make([]byte, size)
+}
+
+// Read the 3bytes big-endian uint24.
+func uint24(b []byte) uint32 {
+	return uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])
+}
+
+// Write the low 3bytes of v in big-endian.
+func putUint24(b []byte, v uint32) {
+	b[0] = byte(v >> 16)
+	b[1] = byte(v >> 8)
+	b[2] = byte(v)
+}
@@ -0,0 +1,147 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2013-2016 Oryx(ossrs)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package rtmp_test
+
+import (
+	"bytes"
+	"github.com/SnailTowardThesun/go-oryx-lib/rtmp"
+	"io/ioutil"
+	"testing"
+)
+
+func TestReadRtmpMessage(t *testing.T) {
+	origin := rtmp.NewRtmpMsgVideo(rtmp.RtmpRandomData(256), 0x123456)
+
+	msg := rtmp.AcquireRtmpMessage()
+	defer rtmp.ReleaseRtmpMessage(msg)
+
+	if err := rtmp.ReadRtmpMessage(bytes.NewReader(origin.Dumps()), msg); err != nil {
+		t.Error("read message failed. err is", err)
+		return
+	}
+
+	if msg.MessageType != origin.MessageType || msg.Timestamp != origin.Timestamp || msg.StreamID != origin.StreamID {
+		t.Errorf("invalid message type=%v, timestamp=%v, stream=%v", msg.MessageType, msg.Timestamp, msg.StreamID)
+		return
+	}
+
+	if !bytes.Equal(msg.PayLoad, origin.PayLoad) {
+		t.Error("payload is invalid")
+		return
+	}
+
+	// The payload is reused for smaller message.
+	p := &msg.PayLoad[0]
+	if err := rtmp.ReadRtmpMessage(bytes.NewReader(rtmp.NewRtmpMsgAudio([]byte{0xaf, 0x01}, 1).Dumps()), msg); err != nil {
+		t.Error("read message failed. err is", err)
+		return
+	} else if &msg.PayLoad[0] != p || len(msg.PayLoad) != 2 {
+		t.Error("payload should be reused")
+		return
+	}
+}
+
+func TestWriteChunkMessages(t *testing.T) {
+	rd := rtmp.RtmpRandomData(512 + 32)
+
+	list, err := rtmp.ChunkMessage(rd, 128, 4, 9, 1234)
+	if err != nil {
+		t.Error("create chunk message failed. err is", err)
+		return
+	}
+
+	var expect []byte
+	for _, c := range list {
+		expect = append(expect, c.Dumps()...)
+	}
+
+	var w bytes.Buffer
+	if n, err := rtmp.WriteChunkMessages(&w, list); err != nil {
+		t.Error("write chunks failed. err is", err)
+		return
+	} else if int(n) != len(expect) || !bytes.Equal(w.Bytes(), expect) {
+		t.Errorf("invalid written size=%v, should be %v", n, len(expect))
+		return
+	}
+}
+
+func BenchmarkParseRtmpMessage(b *testing.B) {
+	data := rtmp.NewRtmpMsgVideo(rtmp.RtmpRandomData(4096), 1).Dumps()
+	r := bytes.NewReader(data)
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		r.Reset(data)
+		if _, err := rtmp.ParseRtmpMessage(r); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkReadRtmpMessage(b *testing.B) {
+	data := rtmp.NewRtmpMsgVideo(rtmp.RtmpRandomData(4096), 1).Dumps()
+	r := bytes.NewReader(data)
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		r.Reset(data)
+		msg := rtmp.AcquireRtmpMessage()
+		if err := rtmp.ReadRtmpMessage(r, msg); err != nil {
+			b.Fatal(err)
+		}
+		rtmp.ReleaseRtmpMessage(msg)
+	}
+}
+
+func BenchmarkRtmpChunkMessage_Read(b *testing.B) {
+	list, err := rtmp.ChunkMessage(rtmp.RtmpRandomData(4096), 4096, 4, 9, 1)
+	if err != nil {
+		b.Fatal(err)
+	}
+	data := list[0].Dumps()
+	r := bytes.NewReader(data)
+
+	var chunk rtmp.RtmpChunkMessage
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		r.Reset(data)
+		if err := chunk.Read(r, 4096); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkWriteChunkMessages(b *testing.B) {
+	msg := rtmp.RtmpRandomData(4096)
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		list, err := rtmp.ChunkMessage(msg, 128, 4, 9, 1)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err := rtmp.WriteChunkMessages(ioutil.Discard, list); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
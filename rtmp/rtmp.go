@@ -31,6 +31,8 @@ import (
 	"math"
 	"math/rand"
 	"net"
+	"net/url"
+	"strings"
 	"time"
 )
 
@@ -280,6 +282,12 @@ type RtmpChunkMessage struct {
 	MessageLength   uint32
 	MessageTypeId   uint8
 	MessageStreamID uint32
+
+	// the buffer for basic header, message header and extended timestamp,
+	// to avoid allocation for each chunk.
+	header [3 + 11 + 4]byte
+	// whether the previous chunk has extended timestamp, the fmt3 chunk follows it.
+	extended bool
 }
 
 func (v *RtmpChunkMessage) GetCSID() uint32 {
@@ -298,14 +306,14 @@ func (v *RtmpChunkMessage) GetCSID() uint32 {
 func (v *RtmpChunkMessage) SetBasicHeaer(format uint8, csId uint32) (err error) {
 	v.Formt = format
 	if csId < 64 {
-		v.BasicHeader = make([]byte, 1)
+		v.BasicHeader = v.header[0:1]
 		v.BasicHeader[0] = byte(format<<6 + uint8(csId)&0x3f)
 	} else if csId < 320 {
-		v.BasicHeader = make([]byte, 2)
+		v.BasicHeader = v.header[0:2]
 		v.BasicHeader[0] = (format << 6) & 0xC0
 		v.BasicHeader[1] = uint8(csId - 64)
 	} else if csId <= 65599 {
-		v.BasicHeader = make([]byte, 3)
+		v.BasicHeader = v.header[0:3]
 		v.BasicHeader[0] = (format<<6)&0xC0 + 1
 		binary.BigEndian.PutUint16(v.BasicHeader[1:3], uint16(csId)-64)
 	}
@@ -319,44 +327,86 @@ func (v *RtmpChunkMessage) GenerateMsgHeader() {
 		return
 	}
 
-	var header bytes.Buffer
-	tmp := make([]byte, 4)
+	header := v.header[3:14]
 
-	binary.BigEndian.PutUint32(tmp, v.Timestamp)
-	header.Write(tmp[1:4])
+	// use extended timestamp when overflow the 3bytes timestamp.
+	if v.Timestamp >= 0xffffff {
+		putUint24(header[0:3], 0xffffff)
+		v.ExtendTimeStamp = v.Timestamp
+	} else {
+		putUint24(header[0:3], v.Timestamp)
+		v.ExtendTimeStamp = 0
+	}
+	putUint24(header[3:6], v.MessageLength)
+	header[6] = v.MessageTypeId
+	binary.BigEndian.PutUint32(header[7:11], v.MessageStreamID)
 
-	binary.BigEndian.PutUint32(tmp, v.MessageLength)
-	header.Write(tmp[1:4])
+	v.MessageHeader = header
+}
 
-	header.Write([]byte{v.MessageTypeId})
+// The buffers of chunk, for vectored write.
+// @remark the buffers refer to the chunk, so never modify chunk before written.
+func (v *RtmpChunkMessage) Buffers() net.Buffers {
+	return v.appendBuffers(make(net.Buffers, 0, 4), v.ExtendTimeStamp)
+}
+
+// Append the buffers of chunk, with the extended timestamp, 0 to ignore.
+func (v *RtmpChunkMessage) appendBuffers(bufs net.Buffers, extendTimeStamp uint32) net.Buffers {
+	bufs = append(bufs, v.BasicHeader, v.MessageHeader)
 
-	binary.BigEndian.PutUint32(tmp, v.MessageStreamID)
-	header.Write(tmp)
+	if extendTimeStamp != 0 {
+		ts := v.header[14:18]
+		binary.BigEndian.PutUint32(ts, extendTimeStamp)
+		bufs = append(bufs, ts)
+	}
 
-	v.MessageHeader = header.Bytes()
+	return append(bufs, v.Data)
 }
 
 func (v RtmpChunkMessage) Dumps() []byte {
-	var msg bytes.Buffer
+	bufs := v.Buffers()
 
-	msg.Write(v.BasicHeader)
-	msg.Write(v.MessageHeader)
+	size := 0
+	for _, b := range bufs {
+		size += len(b)
+	}
 
-	if v.ExtendTimeStamp != 0 {
-		tmp := make([]byte, 4)
-		binary.BigEndian.PutUint32(tmp, v.ExtendTimeStamp)
-		msg.Write(tmp)
+	msg := make([]byte, 0, size)
+	for _, b := range bufs {
+		msg = append(msg, b...)
 	}
 
-	msg.Write(v.Data)
+	return msg
+}
 
-	return msg.Bytes()
+// Write the chunk to w, use writev when w is net.Conn.
+func (v *RtmpChunkMessage) WriteTo(w io.Writer) (n int64, err error) {
+	bufs := v.Buffers()
+	return bufs.WriteTo(w)
 }
 
+// Write all chunks of message to w in one vectored write.
+// @remark the fmt3 chunk use the extended timestamp of previous chunk, even the previous chunk is re-timed.
+func WriteChunkMessages(w io.Writer, list []RtmpChunkMessage) (n int64, err error) {
+	bufs := make(net.Buffers, 0, 4*len(list))
+
+	var extendTimeStamp uint32
+	for i := range list {
+		if list[i].Formt != 3 {
+			extendTimeStamp = list[i].ExtendTimeStamp
+		}
+		bufs = list[i].appendBuffers(bufs, extendTimeStamp)
+	}
+	return bufs.WriteTo(w)
+}
+
+// Read the chunk from reader,
+// @remark the Data is reused when its capacity is enough, so user should copy it when need to keep it.
+// @remark user should reuse the chunk for the same cs id, because the fmt3 chunk has extended timestamp when previous chunk has.
 func (v *RtmpChunkMessage) Read(reader io.Reader, cs uint32) error {
 	v.ChunkSize = cs
 	// decode basic header
-	buf := make([]byte, 1)
+	buf := v.header[0:1]
 	if n, err := io.ReadFull(reader, buf); err != nil {
 		ol.E(nil, "read basic header failed. err is", err)
 		return err
@@ -365,33 +415,27 @@ func (v *RtmpChunkMessage) Read(reader io.Reader, cs uint32) error {
 		return err
 	}
 
-	v.Formt = uint8(buf[0] & 0xC0)
+	v.Formt = uint8(buf[0]>>6) & 0x03
 	csId := buf[0] & 0x3F
 
 	if csId == 0 {
-		v.BasicHeader = make([]byte, 2)
-		v.BasicHeader[0] = buf[0]
-		if n, err := io.ReadFull(reader, buf); err != nil {
+		v.BasicHeader = v.header[0:2]
+		if n, err := io.ReadFull(reader, v.BasicHeader[1:2]); err != nil {
 			ol.E(nil, "read basic header failed. err is", err)
 			return err
 		} else if n != 1 {
 			err = fmt.Errorf("size=%v of readed data invalid, should be %v", n, 1)
 			return err
 		}
-		v.BasicHeader[1] = buf[0]
 	} else if csId == 1 {
-		v.BasicHeader = make([]byte, 3)
-		v.BasicHeader[0] = buf[0]
-
-		buf = make([]byte, 2)
-		if n, err := io.ReadFull(reader, buf); err != nil {
+		v.BasicHeader = v.header[0:3]
+		if n, err := io.ReadFull(reader, v.BasicHeader[1:3]); err != nil {
 			ol.E(nil, "read basic header failed. err is", err)
 			return err
 		} else if n != 2 {
 			err = fmt.Errorf("size=%v of readed data invalid, should be %v", n, 1)
 			return err
 		}
-		v.BasicHeader[1], v.BasicHeader[2] = buf[0], buf[1]
 	} else {
 		v.BasicHeader = buf
 	}
@@ -407,7 +451,7 @@ func (v *RtmpChunkMessage) Read(reader io.Reader, cs uint32) error {
 		messageHeaderLength = 0
 	}
 
-	v.MessageHeader = make([]byte, messageHeaderLength)
+	v.MessageHeader = v.header[3 : 3+messageHeaderLength]
 	if n, err := io.ReadFull(reader, v.MessageHeader); err != nil {
 		ol.E(nil, "read message header failed. err is", err)
 		return err
@@ -416,28 +460,30 @@ func (v *RtmpChunkMessage) Read(reader io.Reader, cs uint32) error {
 		return err
 	}
 
-	conver3bytsTo4bytes := func(msg []byte) []byte {
-		var buf bytes.Buffer
-		buf.Write([]byte{0x0})
-		buf.Write(msg)
-		return buf.Bytes()
-	}
-
 	if v.Formt == 0 {
-		v.Timestamp = binary.BigEndian.Uint32(conver3bytsTo4bytes(v.MessageHeader[0:3]))
-		v.MessageLength = binary.BigEndian.Uint32(conver3bytsTo4bytes(v.MessageHeader[3:6]))
+		v.Timestamp = uint24(v.MessageHeader[0:3])
+		v.MessageLength = uint24(v.MessageHeader[3:6])
 		v.MessageTypeId = uint8(v.MessageHeader[6])
 		v.MessageStreamID = binary.BigEndian.Uint32(v.MessageHeader[7:11])
 	} else if v.Formt == 1 {
-		v.TimestampDelta = binary.BigEndian.Uint32(v.MessageHeader[0:3])
-		v.MessageLength = binary.BigEndian.Uint32(v.MessageHeader[3:6])
+		v.TimestampDelta = uint24(v.MessageHeader[0:3])
+		v.MessageLength = uint24(v.MessageHeader[3:6])
 		v.MessageTypeId = uint8(v.MessageHeader[6])
 	} else if v.Formt == 2 {
-		v.TimestampDelta = binary.BigEndian.Uint32(v.MessageHeader[0:3])
+		v.TimestampDelta = uint24(v.MessageHeader[0:3])
 	}
 
-	if v.Timestamp == 0xffffff {
-		extendTs := make([]byte, 4)
+	// the extended timestamp present when the timestamp or delta is 0xffffff,
+	// and for fmt3 chunk, when the previous chunk has extended timestamp.
+	if v.Formt == 0 {
+		v.extended = v.Timestamp == 0xffffff
+	} else if v.Formt == 1 || v.Formt == 2 {
+		v.extended = v.TimestampDelta == 0xffffff
+	}
+
+	v.ExtendTimeStamp = 0
+	if v.extended {
+		extendTs := v.header[14:18]
 		if n, err := io.ReadFull(reader, extendTs); err != nil {
 			ol.E(nil, "read extend timestamp failed. err is", err)
 			return err
@@ -446,6 +492,12 @@ func (v *RtmpChunkMessage) Read(reader io.Reader, cs uint32) error {
 			return err
 		}
 		v.ExtendTimeStamp = binary.BigEndian.Uint32(extendTs)
+
+		if v.Formt == 0 {
+			v.Timestamp = v.ExtendTimeStamp
+		} else if v.Formt == 1 || v.Formt == 2 {
+			v.TimestampDelta = v.ExtendTimeStamp
+		}
 	}
 
 	msgLength := v.MessageLength
@@ -453,7 +505,7 @@ func (v *RtmpChunkMessage) Read(reader io.Reader, cs uint32) error {
 		msgLength = v.ChunkSize
 	}
 
-	v.Data = make([]byte, msgLength)
+	v.Data = growBuffer(v.Data, int(msgLength))
 	if n, err := io.ReadFull(reader, v.Data); err != nil {
 		ol.E(nil, "read basic header failed. err is", err)
 		return err
@@ -465,10 +517,14 @@ func (v *RtmpChunkMessage) Read(reader io.Reader, cs uint32) error {
 	return nil
 }
 
+// Split the msg to chunks,
+// @remark the Data of chunks refer to msg without copy, so never modify msg before chunks written.
 func ChunkMessage(msg []byte, chunkSize uint32, csId uint32, msgType uint8, streamId uint32) (list []RtmpChunkMessage, err error) {
 	num := int(math.Ceil(float64(len(msg)) / float64(chunkSize)))
+	if num == 0 {
+		num = 1
+	}
 
-	buf := bytes.NewBuffer(msg)
 	list = make([]RtmpChunkMessage, num)
 
 	list[0].SetBasicHeaer(0, csId)
@@ -478,22 +534,20 @@ func ChunkMessage(msg []byte, chunkSize uint32, csId uint32, msgType uint8, stre
 	list[0].MessageStreamID = streamId
 	list[0].GenerateMsgHeader()
 
-	if num > 1 {
-		list[0].Data = make([]byte, chunkSize)
-		buf.Read(list[0].Data)
-		for i := 1; i < num; i++ {
+	for i := 0; i < num; i++ {
+		if i > 0 {
 			list[i].SetBasicHeaer(3, csId)
-			if buf.Len() > int(chunkSize) {
-				list[i].Data = make([]byte, chunkSize)
-				buf.Read(list[i].Data)
-			} else {
-				list[i].Data = make([]byte, buf.Len())
-				buf.Read(list[i].Data)
-			}
+			// the fmt3 chunk carry the extended timestamp, like the first chunk,
+			// which is updated by WriteChunkMessages when first chunk changed.
+			list[i].ExtendTimeStamp = list[0].ExtendTimeStamp
+			list[i].extended = list[0].ExtendTimeStamp != 0
 		}
-	} else {
-		list[0].Data = make([]byte, len(msg))
-		buf.Read(list[0].Data)
+
+		start, end := i*int(chunkSize), (i+1)*int(chunkSize)
+		if end > len(msg) {
+			end = len(msg)
+		}
+		list[i].Data = msg[start:end:end]
 	}
 
 	return
@@ -524,79 +578,78 @@ type RtmpMessage struct {
 	StreamID      uint32
 
 	PayLoad []byte
+
+	// the buffer for header, to avoid allocation for each message.
+	header [11]byte
 }
 
-func (v *RtmpMessage) Dumps() []byte {
-	var msg bytes.Buffer
-	tmp := make([]byte, 4)
+// The buffers of message, for vectored write.
+// @remark the buffers refer to the message, so never modify message before written.
+func (v *RtmpMessage) Buffers() net.Buffers {
+	header := v.header[:]
 
-	msg.Write([]byte{v.MessageType})
+	header[0] = v.MessageType
+	putUint24(header[1:4], v.PayloadLength)
+	binary.BigEndian.PutUint32(header[4:8], v.Timestamp)
+	putUint24(header[8:11], v.StreamID)
 
-	binary.BigEndian.PutUint32(tmp, v.PayloadLength)
-	msg.Write(tmp[1:4])
+	return net.Buffers{header, v.PayLoad}
+}
 
-	binary.BigEndian.PutUint32(tmp, v.Timestamp)
-	msg.Write(tmp)
+func (v *RtmpMessage) Dumps() []byte {
+	bufs := v.Buffers()
 
-	binary.BigEndian.PutUint32(tmp, v.StreamID)
-	msg.Write(tmp[1:4])
+	msg := make([]byte, 0, len(bufs[0])+len(bufs[1]))
+	msg = append(msg, bufs[0]...)
+	msg = append(msg, bufs[1]...)
 
-	msg.Write(v.PayLoad)
+	return msg
+}
 
-	return msg.Bytes()
+// Write the message to w, use writev when w is net.Conn.
+func (v *RtmpMessage) WriteTo(w io.Writer) (n int64, err error) {
+	bufs := v.Buffers()
+	return bufs.WriteTo(w)
 }
 
 func ParseRtmpMessage(reader io.Reader) (*RtmpMessage, error) {
 	msg := &RtmpMessage{}
 
-	buf_1_byte := make([]byte, 1)
-	buf_3_byte := make([]byte, 3)
-	buf_4_byte := make([]byte, 4)
-
-	conver3bytsTo4bytes := func(msg []byte) []byte {
-		var buf bytes.Buffer
-		buf.Write([]byte{0x0})
-		buf.Write(msg)
-		return buf.Bytes()
-	}
-
-	// MessageType
-	if n, err := io.ReadFull(reader, buf_1_byte); err != nil {
-		ol.E(nil, "read message type failed. err is", err)
-		return nil, err
-	} else if n != 1 {
-		err := fmt.Errorf("size=%v of reader data invalid, should be %v", n, 1)
+	if err := ReadRtmpMessage(reader, msg); err != nil {
 		return nil, err
 	}
-	msg.MessageType = uint8(buf_1_byte[0])
 
-	// PayLoadLength
-	if n, err := io.ReadFull(reader, buf_3_byte); err != nil {
-		ol.E(nil, "read payload length failed. err is", err)
-	} else if n != 3 {
-		err := fmt.Errorf("size=%v of reader data invalid, should be %v", n, 3)
-		return nil, err
-	}
-	msg.PayloadLength = binary.BigEndian.Uint32(conver3bytsTo4bytes(buf_3_byte))
+	return msg, nil
+}
 
-	// Timestamp
-	if n, err := io.ReadFull(reader, buf_4_byte); err != nil {
-		ol.E(nil, "read payload length failed. err is", err)
-	} else if n != 4 {
-		err := fmt.Errorf("size=%v of reader data invalid, should be %v", n, 4)
-		return nil, err
+// Read the message from reader to msg, reuse the PayLoad of msg when its capacity is enough.
+// @remark user can use AcquireRtmpMessage to get msg from pool.
+func ReadRtmpMessage(reader io.Reader, msg *RtmpMessage) error {
+	header := msg.header[:]
+
+	if n, err := io.ReadFull(reader, header); err != nil {
+		ol.E(nil, "read message header failed. err is", err)
+		return err
+	} else if n != len(header) {
+		err := fmt.Errorf("size=%v of reader data invalid, should be %v", n, len(header))
+		return err
 	}
-	msg.Timestamp = binary.BigEndian.Uint32(buf_4_byte)
 
-	msg.PayLoad = make([]byte, msg.PayloadLength)
+	msg.MessageType = uint8(header[0])
+	msg.PayloadLength = uint24(header[1:4])
+	msg.Timestamp = binary.BigEndian.Uint32(header[4:8])
+	msg.StreamID = uint24(header[8:11])
+
+	msg.PayLoad = growBuffer(msg.PayLoad, int(msg.PayloadLength))
 	if n, err := io.ReadFull(reader, msg.PayLoad); err != nil {
-		ol.E(nil, "read payload length failed. err is", err)
+		ol.E(nil, "read payload failed. err is", err)
+		return err
 	} else if n != int(msg.PayloadLength) {
 		err := fmt.Errorf("size=%v of reader data invalid, should be %v", n, msg.PayloadLength)
-		return nil, err
+		return err
 	}
 
-	return msg, nil
+	return nil
 }
 
 type RtmpMsgSetChunkSize struct {
@@ -826,6 +879,15 @@ const (
 
 type RtmpMsgSharedObj struct {
 	RtmpMessage
+
+	// the name of shared object.
+	Name string
+	// the current version of shared object.
+	Version uint32
+	// whether the shared object is persistent.
+	Persistent bool
+	// the events in message.
+	Events []RtmpSharedObjEvent
 }
 
 type RtmpClient interface {
@@ -849,6 +911,10 @@ type RtmpClient interface {
 
 type SimpleRtmpClient struct {
 	conn net.Conn
+	// the host, app and tcUrl parsed from url.
+	host  string
+	app   string
+	tcUrl string
 }
 
 func NewSimpleRtmpClient(u string) (RtmpClient, error) {
@@ -872,7 +938,23 @@ func NewSimpleRtmpClient(u string) (RtmpClient, error) {
 }
 
 func (v *SimpleRtmpClient) url_parse(u string) error {
-	// TODO:FIXME: implement this function
+	r, err := url.Parse(u)
+	if err != nil {
+		return fmt.Errorf("parse url %v failed, err is %v", u, err)
+	}
+
+	v.host = r.Host
+	if r.Port() == "" {
+		v.host = net.JoinHostPort(r.Hostname(), "1935")
+	}
+
+	// the app is the path without stream, for example, live of rtmp://host/live/livestream
+	v.app = strings.Trim(r.Path, "/")
+	if pos := strings.LastIndex(v.app, "/"); pos >= 0 {
+		v.app = v.app[:pos]
+	}
+	v.tcUrl = fmt.Sprintf("%v://%v/%v", r.Scheme, r.Host, v.app)
+
 	return nil
 }
 
@@ -884,7 +966,7 @@ func (v *SimpleRtmpClient) initialize(u string) error {
 		return err
 	}
 
-	v.conn, err = net.Dial("tcp", "192.168.1.203:1935")
+	v.conn, err = net.Dial("tcp", v.host)
 	if err != nil {
 		ol.E(nil, "connect to server failed. err is", err)
 		return err
@@ -961,8 +1043,30 @@ func (v *SimpleRtmpClient) handshake() error {
 	return nil
 }
 
+// Send the connect command, with the fourCcList of enhanced RTMP.
 func (v *SimpleRtmpClient) connect() error {
-	// TODO:FIXME: implement this function
+	msg, err := NewRtmpMsgConnect(v.app, v.tcUrl, RtmpEnhancedFourCcList)
+	if err != nil {
+		ol.E(nil, "create connect failed. err is", err)
+		return err
+	}
+
+	list, err := ChunkMessage(msg.PayLoad, 128, 3, msg.MessageType, msg.StreamID)
+	if err != nil {
+		ol.E(nil, "chunk connect failed. err is", err)
+		return err
+	}
+
+	// the timestamp of connect is 0.
+	list[0].Timestamp = 0
+	list[0].GenerateMsgHeader()
+
+	if _, err := WriteChunkMessages(v.conn, list); err != nil {
+		ol.E(nil, "send connect failed. err is", err)
+		return err
+	}
+
+	// TODO:FIXME: read the _result of connect.
 	return nil
 }
 
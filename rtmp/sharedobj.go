@@ -0,0 +1,443 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2013-2016 Oryx(ossrs)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package rtmp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	ol "github.com/SnailTowardThesun/go-oryx-lib/logger"
+	"io"
+	"sync"
+	"time"
+)
+
+// The shared object message, for both AMF0 and AMF3:
+//	name(2B length + utf8), version(4B), flags(8B), events.
+// and each event:
+//	type(1B), data length(4B), data.
+// @remark the AMF3 message is prefixed by a byte 0x00.
+
+// The persistent flag in shared object message.
+const RTMP_SHARED_OBJ_PERSISTENT = 2
+
+// The event in shared object message.
+type RtmpSharedObjEvent struct {
+	// the event type, for example, RTMP_MESSAGE_SHARED_OBJ_EVENT_TYPE_CHANGE.
+	Type uint8
+	// the event data, see NewRtmpSharedObjPropertyEvent.
+	Data []byte
+}
+
+// Create the event with a property, the data is the name followed by an AMF0 value,
+// for CHANGE, REQUEST_CHANGE, SUCCESS, REMOVE and REQUEST_REMOVE,
+// @remark the value is optional, nil to ignore, for example, the REMOVE event.
+func NewRtmpSharedObjPropertyEvent(eventType uint8, name string, value IAMF0Item) (*RtmpSharedObjEvent, error) {
+	if len(name) > 0xffff {
+		err := fmt.Errorf("length of property name should be less than 65535, now is %v", len(name))
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	tmp := make([]byte, 2)
+	binary.BigEndian.PutUint16(tmp, uint16(len(name)))
+	buf.Write(tmp)
+	buf.WriteString(name)
+
+	if value != nil {
+		buf.Write(value.Dumps())
+	}
+
+	return &RtmpSharedObjEvent{Type: eventType, Data: buf.Bytes()}, nil
+}
+
+// Parse the property of event, the name and the AMF0 value which maybe empty.
+func (v *RtmpSharedObjEvent) Property() (name string, value []byte, err error) {
+	if len(v.Data) < 2 {
+		err = fmt.Errorf("size=%v of event data is invalid, should be %v", len(v.Data), 2)
+		return
+	}
+
+	size := int(binary.BigEndian.Uint16(v.Data[0:2]))
+	if len(v.Data) < 2+size {
+		err = fmt.Errorf("size=%v of event data is invalid, should be %v", len(v.Data), 2+size)
+		return
+	}
+
+	return string(v.Data[2 : 2+size]), v.Data[2+size:], nil
+}
+
+// Whether the message is AMF3 shared object message.
+func (v *RtmpMsgSharedObj) IsAMF3() bool {
+	return v.MessageType == RTMP_COMMANDS_SHARED_OBJ_AMF3
+}
+
+// Encode the name, version, flags and events to payload.
+func (v *RtmpMsgSharedObj) encode() error {
+	if len(v.Name) > 0xffff {
+		err := fmt.Errorf("length of shared object name should be less than 65535, now is %v", len(v.Name))
+		return err
+	}
+
+	var buf bytes.Buffer
+	tmp := make([]byte, 8)
+
+	if v.IsAMF3() {
+		buf.WriteByte(0x00)
+	}
+
+	binary.BigEndian.PutUint16(tmp, uint16(len(v.Name)))
+	buf.Write(tmp[0:2])
+	buf.WriteString(v.Name)
+
+	binary.BigEndian.PutUint32(tmp, v.Version)
+	buf.Write(tmp[0:4])
+
+	// the flags, 4B persistent and 4B reserved.
+	for i := range tmp {
+		tmp[i] = 0
+	}
+	if v.Persistent {
+		binary.BigEndian.PutUint32(tmp, RTMP_SHARED_OBJ_PERSISTENT)
+	}
+	buf.Write(tmp)
+
+	for _, e := range v.Events {
+		buf.WriteByte(e.Type)
+		binary.BigEndian.PutUint32(tmp, uint32(len(e.Data)))
+		buf.Write(tmp[0:4])
+		buf.Write(e.Data)
+	}
+
+	v.PayLoad = buf.Bytes()
+	v.PayloadLength = uint32(len(v.PayLoad))
+
+	return nil
+}
+
+// Create the shared object message,
+// @param amf3 whether use AMF3 message type, or AMF0 message type.
+func NewRtmpMsgSharedObj(name string, version uint32, persistent bool, events []RtmpSharedObjEvent, amf3 bool, streamID uint32) (*RtmpMsgSharedObj, error) {
+	msg := &RtmpMsgSharedObj{
+		Name:       name,
+		Version:    version,
+		Persistent: persistent,
+		Events:     events,
+	}
+
+	msg.MessageType = RTMP_COMMANDS_SHARED_OBJ_AMF0
+	if amf3 {
+		msg.MessageType = RTMP_COMMANDS_SHARED_OBJ_AMF3
+	}
+	msg.Timestamp = uint32(time.Now().Unix())
+	msg.StreamID = streamID
+
+	if err := msg.encode(); err != nil {
+		return nil, err
+	}
+
+	return msg, nil
+}
+
+// Parse the shared object message from the AMF0 or AMF3 message.
+func ParseRtmpMsgSharedObj(m *RtmpMessage) (*RtmpMsgSharedObj, error) {
+	if m.MessageType != RTMP_COMMANDS_SHARED_OBJ_AMF0 && m.MessageType != RTMP_COMMANDS_SHARED_OBJ_AMF3 {
+		err := fmt.Errorf("type=%v of message is not shared object", m.MessageType)
+		return nil, err
+	}
+
+	msg := &RtmpMsgSharedObj{RtmpMessage: *m}
+	reader := bytes.NewReader(m.PayLoad)
+
+	if msg.IsAMF3() {
+		if _, err := reader.ReadByte(); err != nil {
+			return nil, err
+		}
+	}
+
+	tmp := make([]byte, 8)
+	if _, err := io.ReadFull(reader, tmp[0:2]); err != nil {
+		return nil, err
+	}
+
+	name := make([]byte, binary.BigEndian.Uint16(tmp[0:2]))
+	if _, err := io.ReadFull(reader, name); err != nil {
+		return nil, err
+	}
+	msg.Name = string(name)
+
+	if _, err := io.ReadFull(reader, tmp[0:4]); err != nil {
+		return nil, err
+	}
+	msg.Version = binary.BigEndian.Uint32(tmp[0:4])
+
+	if _, err := io.ReadFull(reader, tmp); err != nil {
+		return nil, err
+	}
+	msg.Persistent = binary.BigEndian.Uint32(tmp[0:4]) == RTMP_SHARED_OBJ_PERSISTENT
+
+	for reader.Len() > 0 {
+		e := RtmpSharedObjEvent{}
+
+		if _, err := io.ReadFull(reader, tmp[0:5]); err != nil {
+			return nil, err
+		}
+		e.Type = tmp[0]
+
+		size := binary.BigEndian.Uint32(tmp[1:5])
+		if int64(size) > int64(reader.Len()) {
+			err := fmt.Errorf("size=%v of event data is invalid, only %v left", size, reader.Len())
+			return nil, err
+		}
+
+		e.Data = make([]byte, size)
+		if _, err := io.ReadFull(reader, e.Data); err != nil {
+			return nil, err
+		}
+
+		msg.Events = append(msg.Events, e)
+	}
+
+	return msg, nil
+}
+
+// The subscriber of shared object, for example, the rtmp connection.
+type RtmpSharedObjSubscriber interface {
+	// Send the shared object message to subscriber.
+	SendSharedObj(msg *RtmpMsgSharedObj) error
+}
+
+// The shared object in server.
+type rtmpSharedObj struct {
+	name       string
+	version    uint32
+	persistent bool
+	// the property name and AMF0 value.
+	properties  map[string][]byte
+	subscribers map[RtmpSharedObjSubscriber]bool
+	// whether the subscriber use AMF3.
+	amf3 map[RtmpSharedObjSubscriber]bool
+}
+
+// The server-side shared objects, which apply the events from subscribers
+// and broadcast the changes to all subscribers.
+type RtmpSharedObjRegistry struct {
+	ctx     ol.Context
+	lock    *sync.Mutex
+	objects map[string]*rtmpSharedObj
+}
+
+func NewRtmpSharedObjRegistry(ctx ol.Context) *RtmpSharedObjRegistry {
+	return &RtmpSharedObjRegistry{
+		ctx:     ctx,
+		lock:    &sync.Mutex{},
+		objects: make(map[string]*rtmpSharedObj),
+	}
+}
+
+// Get the properties of shared object, nil if not exists.
+func (v *RtmpSharedObjRegistry) Properties(name string) map[string][]byte {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	so, ok := v.objects[name]
+	if !ok {
+		return nil
+	}
+
+	properties := make(map[string][]byte)
+	for k, p := range so.properties {
+		properties[k] = p
+	}
+	return properties
+}
+
+// Handle the shared object message from subscriber.
+// @remark the messages are sent to subscribers without lock, so subscriber can call registry when sending.
+func (v *RtmpSharedObjRegistry) Handle(sub RtmpSharedObjSubscriber, msg *RtmpMsgSharedObj) error {
+	v.lock.Lock()
+	outgoing, err := v.handle(sub, msg)
+	v.lock.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	for _, o := range outgoing {
+		v.send(o)
+	}
+
+	return nil
+}
+
+// The message to send to subscriber, which is built with lock held.
+type rtmpSharedObjOutgoing struct {
+	sub RtmpSharedObjSubscriber
+	msg *RtmpMsgSharedObj
+}
+
+// Apply the events of message with lock held, @return the messages to send.
+// @remark the events are validated before applied, so the shared object is never partially changed.
+func (v *RtmpSharedObjRegistry) handle(sub RtmpSharedObjSubscriber, msg *RtmpMsgSharedObj) ([]*rtmpSharedObjOutgoing, error) {
+	for _, e := range msg.Events {
+		if e.Type != RTMP_MESSAGE_SHARED_OBJ_EVENT_TYPE_REQUEST_CHANGE && e.Type != RTMP_MESSAGE_SHARED_OBJ_EVENT_TYPE_REQUEST_REMOVE {
+			continue
+		}
+		if _, _, err := e.Property(); err != nil {
+			return nil, err
+		}
+	}
+
+	so, ok := v.objects[msg.Name]
+	if !ok {
+		so = &rtmpSharedObj{
+			name:        msg.Name,
+			persistent:  msg.Persistent,
+			properties:  make(map[string][]byte),
+			subscribers: make(map[RtmpSharedObjSubscriber]bool),
+			amf3:        make(map[RtmpSharedObjSubscriber]bool),
+		}
+		v.objects[msg.Name] = so
+	}
+
+	// the events to requester, and to other subscribers,
+	// for instance, the requester got SUCCESS while others got CHANGE.
+	var replies, changes []RtmpSharedObjEvent
+	var changed bool
+
+	for _, e := range msg.Events {
+		switch e.Type {
+		case RTMP_MESSAGE_SHARED_OBJ_EVENT_TYPE_USE:
+			so.subscribers[sub] = true
+			so.amf3[sub] = msg.IsAMF3()
+
+			replies = append(replies, RtmpSharedObjEvent{Type: RTMP_MESSAGE_SHARED_OBJ_EVENT_TYPE_USE_SUCCESS})
+			replies = append(replies, RtmpSharedObjEvent{Type: RTMP_MESSAGE_SHARED_OBJ_EVENT_TYPE_CLEAR})
+			for name, value := range so.properties {
+				if e, err := newRtmpSharedObjPropertyEvent(RTMP_MESSAGE_SHARED_OBJ_EVENT_TYPE_CHANGE, name, value); err == nil {
+					replies = append(replies, *e)
+				}
+			}
+		case RTMP_MESSAGE_SHARED_OBJ_EVENT_TYPE_RELEASE:
+			v.release(so, sub)
+		case RTMP_MESSAGE_SHARED_OBJ_EVENT_TYPE_REQUEST_CHANGE:
+			name, value, _ := e.Property()
+			so.properties[name] = value
+			changed = true
+
+			replies = append(replies, RtmpSharedObjEvent{Type: RTMP_MESSAGE_SHARED_OBJ_EVENT_TYPE_SUCCESS, Data: e.Data[:2+len(name)]})
+			changes = append(changes, RtmpSharedObjEvent{Type: RTMP_MESSAGE_SHARED_OBJ_EVENT_TYPE_CHANGE, Data: e.Data})
+		case RTMP_MESSAGE_SHARED_OBJ_EVENT_TYPE_REQUEST_REMOVE:
+			name, _, _ := e.Property()
+			delete(so.properties, name)
+			changed = true
+
+			remove := RtmpSharedObjEvent{Type: RTMP_MESSAGE_SHARED_OBJ_EVENT_TYPE_REMOVE, Data: e.Data[:2+len(name)]}
+			replies = append(replies, remove)
+			changes = append(changes, remove)
+		case RTMP_MESSAGE_SHARED_OBJ_EVENT_TYPE_SEND_MESSAGE:
+			changed = true
+			replies = append(replies, e)
+			changes = append(changes, e)
+		default:
+			ol.W(v.ctx, "ignore shared object", msg.Name, "event", e.Type)
+		}
+	}
+
+	if changed {
+		so.version++
+	}
+
+	var outgoing []*rtmpSharedObjOutgoing
+
+	if len(replies) > 0 && so.subscribers[sub] {
+		if o := v.message(so, sub, replies); o != nil {
+			outgoing = append(outgoing, o)
+		}
+	}
+
+	if len(changes) > 0 {
+		for s := range so.subscribers {
+			if s == sub {
+				continue
+			}
+			if o := v.message(so, s, changes); o != nil {
+				outgoing = append(outgoing, o)
+			}
+		}
+	}
+
+	if len(so.subscribers) == 0 && !so.persistent {
+		delete(v.objects, so.name)
+	}
+
+	return outgoing, nil
+}
+
+// Remove the subscriber from all shared objects, for example, when connection closed.
+func (v *RtmpSharedObjRegistry) Unsubscribe(sub RtmpSharedObjSubscriber) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	for _, so := range v.objects {
+		v.release(so, sub)
+	}
+}
+
+func (v *RtmpSharedObjRegistry) release(so *rtmpSharedObj, sub RtmpSharedObjSubscriber) {
+	delete(so.subscribers, sub)
+	delete(so.amf3, sub)
+
+	// the temporary shared object is destroyed when no subscriber.
+	if len(so.subscribers) == 0 && !so.persistent {
+		delete(v.objects, so.name)
+	}
+}
+
+// Create the message of events for subscriber, with lock held, nil if failed.
+func (v *RtmpSharedObjRegistry) message(so *rtmpSharedObj, sub RtmpSharedObjSubscriber, events []RtmpSharedObjEvent) *rtmpSharedObjOutgoing {
+	msg, err := NewRtmpMsgSharedObj(so.name, so.version, so.persistent, events, so.amf3[sub], 0)
+	if err != nil {
+		ol.W(v.ctx, "create shared object", so.name, "message failed. err is", err)
+		return nil
+	}
+
+	return &rtmpSharedObjOutgoing{sub: sub, msg: msg}
+}
+
+// Send the message to subscriber, without lock.
+func (v *RtmpSharedObjRegistry) send(o *rtmpSharedObjOutgoing) {
+	if err := o.sub.SendSharedObj(o.msg); err != nil {
+		ol.W(v.ctx, "send shared object", o.msg.Name, "to subscriber failed. err is", err)
+	}
+}
+
+// Create the property event with encoded AMF0 value.
+func newRtmpSharedObjPropertyEvent(eventType uint8, name string, value []byte) (*RtmpSharedObjEvent, error) {
+	e, err := NewRtmpSharedObjPropertyEvent(eventType, name, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	e.Data = append(e.Data, value...)
+	return e, nil
+}
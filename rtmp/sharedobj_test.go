@@ -0,0 +1,211 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2013-2016 Oryx(ossrs)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package rtmp_test
+
+import (
+	"bytes"
+	"github.com/SnailTowardThesun/go-oryx-lib/rtmp"
+	"testing"
+	"time"
+)
+
+func TestParseRtmpMsgSharedObj(t *testing.T) {
+	for _, amf3 := range []bool{false, true} {
+		e, err := rtmp.NewRtmpSharedObjPropertyEvent(rtmp.RTMP_MESSAGE_SHARED_OBJ_EVENT_TYPE_REQUEST_CHANGE, "count", rtmp.NewAMF0Number(10))
+		if err != nil {
+			t.Error("create event failed. err is", err)
+			return
+		}
+
+		events := []rtmp.RtmpSharedObjEvent{{Type: rtmp.RTMP_MESSAGE_SHARED_OBJ_EVENT_TYPE_USE}, *e}
+		msg, err := rtmp.NewRtmpMsgSharedObj("chat", 3, true, events, amf3, 1)
+		if err != nil {
+			t.Error("create shared object message failed. err is", err)
+			return
+		}
+
+		pkt, err := rtmp.ParseRtmpMsgSharedObj(&msg.RtmpMessage)
+		if err != nil {
+			t.Error("parse shared object message failed. err is", err)
+			return
+		}
+
+		if pkt.IsAMF3() != amf3 || pkt.Name != "chat" || pkt.Version != 3 || !pkt.Persistent || len(pkt.Events) != 2 {
+			t.Errorf("invalid message amf3=%v, name=%v, version=%v, persistent=%v, events=%v",
+				pkt.IsAMF3(), pkt.Name, pkt.Version, pkt.Persistent, len(pkt.Events))
+			return
+		}
+
+		if pkt.Events[0].Type != rtmp.RTMP_MESSAGE_SHARED_OBJ_EVENT_TYPE_USE || len(pkt.Events[0].Data) != 0 {
+			t.Errorf("invalid use event %v", pkt.Events[0])
+			return
+		}
+
+		name, value, err := pkt.Events[1].Property()
+		if err != nil {
+			t.Error("parse property failed. err is", err)
+			return
+		}
+
+		if name != "count" || !bytes.Equal(value, rtmp.NewAMF0Number(10).Dumps()) {
+			t.Errorf("invalid property name=%v, value=%v", name, value)
+			return
+		}
+	}
+
+	if _, err := rtmp.ParseRtmpMsgSharedObj(&rtmp.NewRtmpMsgAudio(nil, 1).RtmpMessage); err == nil {
+		t.Error("should fail for audio message")
+		return
+	}
+}
+
+type mockSharedObjSubscriber struct {
+	msgs []*rtmp.RtmpMsgSharedObj
+}
+
+func (v *mockSharedObjSubscriber) SendSharedObj(msg *rtmp.RtmpMsgSharedObj) error {
+	v.msgs = append(v.msgs, msg)
+	return nil
+}
+
+func TestRtmpSharedObjRegistry(t *testing.T) {
+	r := rtmp.NewRtmpSharedObjRegistry(nil)
+	a, b := &mockSharedObjSubscriber{}, &mockSharedObjSubscriber{}
+
+	use := []rtmp.RtmpSharedObjEvent{{Type: rtmp.RTMP_MESSAGE_SHARED_OBJ_EVENT_TYPE_USE}}
+	for _, s := range []*mockSharedObjSubscriber{a, b} {
+		msg, _ := rtmp.NewRtmpMsgSharedObj("chat", 0, false, use, false, 0)
+		if err := r.Handle(s, msg); err != nil {
+			t.Error("use shared object failed. err is", err)
+			return
+		}
+	}
+
+	if len(a.msgs) != 1 || a.msgs[0].Events[0].Type != rtmp.RTMP_MESSAGE_SHARED_OBJ_EVENT_TYPE_USE_SUCCESS {
+		t.Errorf("invalid use reply %v", a.msgs)
+		return
+	}
+
+	e, _ := rtmp.NewRtmpSharedObjPropertyEvent(rtmp.RTMP_MESSAGE_SHARED_OBJ_EVENT_TYPE_REQUEST_CHANGE, "count", rtmp.NewAMF0Number(10))
+	msg, _ := rtmp.NewRtmpMsgSharedObj("chat", 0, false, []rtmp.RtmpSharedObjEvent{*e}, false, 0)
+	if err := r.Handle(a, msg); err != nil {
+		t.Error("change shared object failed. err is", err)
+		return
+	}
+
+	// The requester only got success, others got change.
+	if len(a.msgs) != 2 || len(a.msgs[1].Events) != 1 || a.msgs[1].Events[0].Type != rtmp.RTMP_MESSAGE_SHARED_OBJ_EVENT_TYPE_SUCCESS {
+		t.Errorf("invalid requester messages %v", a.msgs)
+		return
+	}
+	if len(b.msgs) != 2 || b.msgs[1].Events[0].Type != rtmp.RTMP_MESSAGE_SHARED_OBJ_EVENT_TYPE_CHANGE || b.msgs[1].Version != 1 {
+		t.Errorf("invalid subscriber messages %v", b.msgs)
+		return
+	}
+
+	if p := r.Properties("chat"); len(p) != 1 {
+		t.Errorf("invalid properties %v", p)
+		return
+	}
+
+	// The temporary shared object is removed when no subscriber.
+	r.Unsubscribe(a)
+	r.Unsubscribe(b)
+	if p := r.Properties("chat"); p != nil {
+		t.Errorf("shared object should be removed, properties %v", p)
+		return
+	}
+}
+
+func TestRtmpSharedObjRegistry_InvalidEvent(t *testing.T) {
+	r := rtmp.NewRtmpSharedObjRegistry(nil)
+	a := &mockSharedObjSubscriber{}
+
+	use := []rtmp.RtmpSharedObjEvent{{Type: rtmp.RTMP_MESSAGE_SHARED_OBJ_EVENT_TYPE_USE}}
+	msg, _ := rtmp.NewRtmpMsgSharedObj("chat", 0, true, use, false, 0)
+	if err := r.Handle(a, msg); err != nil {
+		t.Error("use shared object failed. err is", err)
+		return
+	}
+
+	// The valid change before the malformed event should not be applied.
+	e, _ := rtmp.NewRtmpSharedObjPropertyEvent(rtmp.RTMP_MESSAGE_SHARED_OBJ_EVENT_TYPE_REQUEST_CHANGE, "count", rtmp.NewAMF0Number(10))
+	events := []rtmp.RtmpSharedObjEvent{*e, {Type: rtmp.RTMP_MESSAGE_SHARED_OBJ_EVENT_TYPE_REQUEST_REMOVE, Data: []byte{0x00}}}
+	msg, _ = rtmp.NewRtmpMsgSharedObj("chat", 0, true, events, false, 0)
+	if err := r.Handle(a, msg); err == nil {
+		t.Error("should fail for malformed event")
+		return
+	}
+
+	if p := r.Properties("chat"); len(p) != 0 || len(a.msgs) != 1 {
+		t.Errorf("shared object should not change, properties %v, messages %v", p, a.msgs)
+		return
+	}
+
+	// The temporary shared object is not created for malformed event.
+	msg, _ = rtmp.NewRtmpMsgSharedObj("temp", 0, false, events, false, 0)
+	if err := r.Handle(a, msg); err == nil {
+		t.Error("should fail for malformed event")
+		return
+	}
+
+	if p := r.Properties("temp"); p != nil {
+		t.Errorf("shared object should not be created, properties %v", p)
+		return
+	}
+}
+
+// The subscriber which unsubscribe when got message, for example, the connection is closed.
+type mockSharedObjUnsubscriber struct {
+	r *rtmp.RtmpSharedObjRegistry
+}
+
+func (v *mockSharedObjUnsubscriber) SendSharedObj(msg *rtmp.RtmpMsgSharedObj) error {
+	v.r.Unsubscribe(v)
+	return nil
+}
+
+func TestRtmpSharedObjRegistry_SendWithoutLock(t *testing.T) {
+	r := rtmp.NewRtmpSharedObjRegistry(nil)
+	s := &mockSharedObjUnsubscriber{r: r}
+
+	done := make(chan error, 1)
+	go func() {
+		use := []rtmp.RtmpSharedObjEvent{{Type: rtmp.RTMP_MESSAGE_SHARED_OBJ_EVENT_TYPE_USE}}
+		msg, _ := rtmp.NewRtmpMsgSharedObj("chat", 0, false, use, false, 0)
+		done <- r.Handle(s, msg)
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error("use shared object failed. err is", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Error("deadlock when subscriber call registry")
+		return
+	}
+
+	if p := r.Properties("chat"); p != nil {
+		t.Errorf("shared object should be removed, properties %v", p)
+	}
+}
@@ -0,0 +1,329 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2013-2016 Oryx(ossrs)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package rtmp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// The video tag header, both legacy FLV and enhanced RTMP(E-RTMP).
+// @see https://veovera.org/docs/enhanced/enhanced-rtmp-v1.pdf
+
+// video frame type, the 3bits(enhanced) or 4bits(legacy) in the first byte.
+const (
+	RTMP_VIDEO_FRAME_TYPE_KEY_FRAME              = 1
+	RTMP_VIDEO_FRAME_TYPE_INTER_FRAME            = 2
+	RTMP_VIDEO_FRAME_TYPE_DISPOSABLE_INTER_FRAME = 3
+	RTMP_VIDEO_FRAME_TYPE_GENERATED_KEY_FRAME    = 4
+	RTMP_VIDEO_FRAME_TYPE_INFO_FRAME             = 5
+)
+
+// legacy video codec id, the low 4bits in the first byte.
+const (
+	RTMP_VIDEO_CODEC_SORENSON_H263 = 2
+	RTMP_VIDEO_CODEC_SCREEN_VIDEO  = 3
+	RTMP_VIDEO_CODEC_ON2_VP6       = 4
+	RTMP_VIDEO_CODEC_ON2_VP6_ALPHA = 5
+	RTMP_VIDEO_CODEC_SCREEN_VIDEO2 = 6
+	RTMP_VIDEO_CODEC_AVC           = 7
+)
+
+// legacy avc packet type, the byte follows the first byte for AVC.
+const (
+	RTMP_AVC_PACKET_TYPE_SEQUENCE_HEADER = 0
+	RTMP_AVC_PACKET_TYPE_NALU            = 1
+	RTMP_AVC_PACKET_TYPE_END_OF_SEQUENCE = 2
+)
+
+// enhanced video packet type, the low 4bits in the first byte when IsExHeader.
+const (
+	RTMP_VIDEO_PACKET_TYPE_SEQUENCE_START         = 0
+	RTMP_VIDEO_PACKET_TYPE_CODED_FRAMES           = 1
+	RTMP_VIDEO_PACKET_TYPE_SEQUENCE_END           = 2
+	RTMP_VIDEO_PACKET_TYPE_CODED_FRAMESX          = 3
+	RTMP_VIDEO_PACKET_TYPE_METADATA               = 4
+	RTMP_VIDEO_PACKET_TYPE_MPEG2TS_SEQUENCE_START = 5
+)
+
+// the IsExHeader flag, the highest bit in the first byte.
+const RTMP_VIDEO_EX_HEADER = 0x80
+
+// enhanced video codec fourcc.
+const (
+	RTMP_FOURCC_AV1  = uint32('a')<<24 | uint32('v')<<16 | uint32('0')<<8 | uint32('1')
+	RTMP_FOURCC_VP9  = uint32('v')<<24 | uint32('p')<<16 | uint32('0')<<8 | uint32('9')
+	RTMP_FOURCC_HEVC = uint32('h')<<24 | uint32('v')<<16 | uint32('c')<<8 | uint32('1')
+)
+
+// The fourCcList in connect command object, the enhanced codecs we support.
+var RtmpEnhancedFourCcList = []string{"av01", "vp09", "hvc1"}
+
+// Convert the fourcc to string, for example, hvc1.
+func RtmpFourCcString(fourCc uint32) string {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, fourCc)
+	return string(b)
+}
+
+// Convert the string to fourcc, for example, hvc1.
+func ParseRtmpFourCc(fourCc string) (uint32, error) {
+	if len(fourCc) != 4 {
+		err := fmt.Errorf("size=%v of fourcc %v is invalid, should be 4", len(fourCc), fourCc)
+		return 0, err
+	}
+	return binary.BigEndian.Uint32([]byte(fourCc)), nil
+}
+
+// Create the fourCcList strict array for connect command object.
+func NewAMF0FourCcList(fourCcs []string) (*AMF0StrictArray, error) {
+	it, err := NewAMF0StrictArray()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, fourCc := range fourCcs {
+		if _, err := ParseRtmpFourCc(fourCc); err != nil {
+			return nil, err
+		}
+
+		str, err := NewAMF0String([]byte(fourCc))
+		if err != nil {
+			return nil, err
+		}
+		it.Write(&str.AMF0Item)
+	}
+
+	return it, nil
+}
+
+// Parse the fourCcList strict array from connect command object.
+// @remark the items which are not fourcc string are ignored.
+func ParseAMF0FourCcList(it *AMF0StrictArray) (fourCcs []string) {
+	for i := it.ArrayList.Front(); i != nil; i = i.Next() {
+		var b []byte
+		if str, ok := i.Value.(*AMF0String); ok {
+			b = str.Bytes
+		} else if item, ok := i.Value.(*AMF0Item); ok && item.IsString() && len(item.Payload) > 2 {
+			b = item.Payload[2:]
+		}
+
+		if len(b) == 4 {
+			fourCcs = append(fourCcs, string(b))
+		}
+	}
+	return
+}
+
+// Create the command object of connect, with the fourCcList to advertise the enhanced codecs,
+// for example, NewRtmpConnectObject("live", "rtmp://ossrs.net/live", RtmpEnhancedFourCcList)
+// @remark the fourCcList is ignored when empty, for legacy RTMP.
+func NewRtmpConnectObject(app, tcUrl string, fourCcs []string) (*AMF0Object, error) {
+	obj, err := NewAMF0Object()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, p := range [][2]string{{"app", app}, {"tcUrl", tcUrl}} {
+		str, err := NewAMF0String([]byte(p[1]))
+		if err != nil {
+			return nil, err
+		}
+		obj.Write([]byte(p[0]), str)
+	}
+
+	if len(fourCcs) > 0 {
+		arr, err := NewAMF0FourCcList(fourCcs)
+		if err != nil {
+			return nil, err
+		}
+		obj.WriteStrictArray([]byte("fourCcList"), arr)
+	}
+
+	return obj, nil
+}
+
+// Create the connect command message, with the command object by NewRtmpConnectObject,
+// @remark the transaction id of connect is always 1.
+func NewRtmpMsgConnect(app, tcUrl string, fourCcs []string) (*RtmpMsgCommand, error) {
+	obj, err := NewRtmpConnectObject(app, tcUrl, fourCcs)
+	if err != nil {
+		return nil, err
+	}
+
+	name, err := NewAMF0String([]byte("connect"))
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	buf.Write(name.Dumps())
+	buf.Write(NewAMF0Number(1).Dumps())
+	buf.Write(obj.Dumps())
+
+	msg := &RtmpMsgCommand{}
+	msg.MessageType = RTMP_COMMADNS_MSG_COMMAND_AMF0
+	msg.PayLoad = buf.Bytes()
+	msg.PayloadLength = uint32(len(msg.PayLoad))
+
+	return msg, nil
+}
+
+// Parse the fourCcList from the command object of connect,
+// @return nil when peer is legacy RTMP, which not support the enhanced codecs.
+func ParseRtmpConnectFourCcList(obj *AMF0Object) []string {
+	if arr, ok := obj.Arrays["fourCcList"]; ok {
+		return ParseAMF0FourCcList(arr)
+	}
+	return nil
+}
+
+// The video tag header, legacy or enhanced.
+type RtmpVideoTagHeader struct {
+	// the frame type, for example, RTMP_VIDEO_FRAME_TYPE_KEY_FRAME.
+	FrameType uint8
+	// whether enhanced RTMP header, FourCC and PacketType are valid when true.
+	IsExHeader bool
+
+	// legacy codec id, valid when not IsExHeader.
+	CodecID uint8
+	// legacy AVC packet type, valid when CodecID is AVC.
+	AVCPacketType uint8
+
+	// enhanced packet type, valid when IsExHeader.
+	PacketType uint8
+	// enhanced codec fourcc, valid when IsExHeader.
+	FourCC uint32
+
+	// the composition time offset in ms, for AVC NALU and HEVC CodedFrames.
+	CompositionTime int32
+}
+
+// Whether the header is sequence header, for both legacy and enhanced.
+func (v *RtmpVideoTagHeader) IsSequenceHeader() bool {
+	if v.IsExHeader {
+		return v.PacketType == RTMP_VIDEO_PACKET_TYPE_SEQUENCE_START || v.PacketType == RTMP_VIDEO_PACKET_TYPE_MPEG2TS_SEQUENCE_START
+	}
+	return v.CodecID == RTMP_VIDEO_CODEC_AVC && v.AVCPacketType == RTMP_AVC_PACKET_TYPE_SEQUENCE_HEADER
+}
+
+// Whether the frame is keyframe.
+func (v *RtmpVideoTagHeader) IsKeyFrame() bool {
+	return v.FrameType == RTMP_VIDEO_FRAME_TYPE_KEY_FRAME
+}
+
+// Whether there is a composition time in the header.
+func (v *RtmpVideoTagHeader) hasCompositionTime() bool {
+	if v.IsExHeader {
+		// For HEVC, the CodedFramesX is the CodedFrames with composition time zero.
+		return v.FourCC == RTMP_FOURCC_HEVC && v.PacketType == RTMP_VIDEO_PACKET_TYPE_CODED_FRAMES
+	}
+	return v.CodecID == RTMP_VIDEO_CODEC_AVC
+}
+
+func (v *RtmpVideoTagHeader) Dumps() []byte {
+	var b []byte
+
+	if v.IsExHeader {
+		b = make([]byte, 5)
+		b[0] = RTMP_VIDEO_EX_HEADER | (v.FrameType&0x07)<<4 | v.PacketType&0x0f
+		binary.BigEndian.PutUint32(b[1:5], v.FourCC)
+	} else if v.CodecID == RTMP_VIDEO_CODEC_AVC {
+		b = make([]byte, 2)
+		b[0] = (v.FrameType&0x0f)<<4 | v.CodecID&0x0f
+		b[1] = v.AVCPacketType
+	} else {
+		b = make([]byte, 1)
+		b[0] = (v.FrameType&0x0f)<<4 | v.CodecID&0x0f
+	}
+
+	if v.hasCompositionTime() {
+		b = append(b, byte(v.CompositionTime>>16), byte(v.CompositionTime>>8), byte(v.CompositionTime))
+	}
+
+	return b
+}
+
+// Parse the video tag header from the payload of video message,
+// @return the header and the left codec data, for example, the HEVCDecoderConfigurationRecord.
+func ParseRtmpVideoTagHeader(payload []byte) (*RtmpVideoTagHeader, []byte, error) {
+	if len(payload) < 1 {
+		err := fmt.Errorf("size=%v of video tag is invalid, should be %v", len(payload), 1)
+		return nil, nil, err
+	}
+
+	v := &RtmpVideoTagHeader{}
+	p := payload
+
+	if v.IsExHeader = (p[0] & RTMP_VIDEO_EX_HEADER) == RTMP_VIDEO_EX_HEADER; v.IsExHeader {
+		if len(p) < 5 {
+			err := fmt.Errorf("size=%v of enhanced video tag is invalid, should be %v", len(p), 5)
+			return nil, nil, err
+		}
+		v.FrameType = (p[0] >> 4) & 0x07
+		v.PacketType = p[0] & 0x0f
+		v.FourCC = binary.BigEndian.Uint32(p[1:5])
+		p = p[5:]
+	} else {
+		v.FrameType = (p[0] >> 4) & 0x0f
+		v.CodecID = p[0] & 0x0f
+		p = p[1:]
+
+		if v.CodecID == RTMP_VIDEO_CODEC_AVC {
+			if len(p) < 1 {
+				err := fmt.Errorf("size=%v of avc video tag is invalid, should be %v", len(p), 1)
+				return nil, nil, err
+			}
+			v.AVCPacketType = p[0]
+			p = p[1:]
+		}
+	}
+
+	if v.hasCompositionTime() {
+		if len(p) < 3 {
+			err := fmt.Errorf("size=%v of composition time is invalid, should be %v", len(p), 3)
+			return nil, nil, err
+		}
+		// SI24, sign extend from 24bits to 32bits.
+		v.CompositionTime = int32(uint32(p[0])<<24|uint32(p[1])<<16|uint32(p[2])<<8) >> 8
+		p = p[3:]
+	}
+
+	return v, p, nil
+}
+
+// Parse the video tag header of message, see ParseRtmpVideoTagHeader.
+func (v *RtmpMsgVideo) TagHeader() (*RtmpVideoTagHeader, []byte, error) {
+	return ParseRtmpVideoTagHeader(v.PayLoad)
+}
+
+// Create the video message with tag header, for publisher to send the enhanced video.
+func NewRtmpMsgVideoWithHeader(header *RtmpVideoTagHeader, data []byte, streamID uint32) *RtmpMsgVideo {
+	b := header.Dumps()
+
+	payLoad := make([]byte, len(b)+len(data))
+	copy(payLoad, b)
+	copy(payLoad[len(b):], data)
+
+	return NewRtmpMsgVideo(payLoad, streamID)
+}
@@ -0,0 +1,237 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2013-2016 Oryx(ossrs)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package rtmp_test
+
+import (
+	"bytes"
+	"github.com/SnailTowardThesun/go-oryx-lib/rtmp"
+	"testing"
+)
+
+func TestParseRtmpVideoTagHeader_Legacy(t *testing.T) {
+	// keyframe, avc, nalu, cts=-2
+	payload := []byte{0x17, 0x01, 0xff, 0xff, 0xfe, 0xaa, 0xbb}
+
+	h, data, err := rtmp.ParseRtmpVideoTagHeader(payload)
+	if err != nil {
+		t.Error("parse legacy video tag failed. err is", err)
+		return
+	}
+
+	if h.IsExHeader || !h.IsKeyFrame() || h.CodecID != rtmp.RTMP_VIDEO_CODEC_AVC {
+		t.Errorf("invalid header %+v", h)
+		return
+	}
+
+	if h.AVCPacketType != rtmp.RTMP_AVC_PACKET_TYPE_NALU || h.CompositionTime != -2 {
+		t.Errorf("invalid avc packet type=%v or cts=%v", h.AVCPacketType, h.CompositionTime)
+		return
+	}
+
+	if !bytes.Equal(data, []byte{0xaa, 0xbb}) {
+		t.Errorf("invalid data %v", data)
+		return
+	}
+
+	if !bytes.Equal(h.Dumps(), payload[:5]) {
+		t.Errorf("invalid dumps %v", h.Dumps())
+		return
+	}
+}
+
+func TestParseRtmpVideoTagHeader_Enhanced(t *testing.T) {
+	h := &rtmp.RtmpVideoTagHeader{
+		FrameType:       rtmp.RTMP_VIDEO_FRAME_TYPE_KEY_FRAME,
+		IsExHeader:      true,
+		PacketType:      rtmp.RTMP_VIDEO_PACKET_TYPE_CODED_FRAMES,
+		FourCC:          rtmp.RTMP_FOURCC_HEVC,
+		CompositionTime: 40,
+	}
+
+	msg := rtmp.NewRtmpMsgVideoWithHeader(h, []byte{0xaa}, 1)
+	if len(msg.PayLoad) != 5+3+1 || msg.PayLoad[0] != 0x91 {
+		t.Errorf("invalid payload %v", msg.PayLoad)
+		return
+	}
+
+	nh, data, err := msg.TagHeader()
+	if err != nil {
+		t.Error("parse enhanced video tag failed. err is", err)
+		return
+	}
+
+	if *nh != *h {
+		t.Errorf("header %+v should be %+v", nh, h)
+		return
+	}
+
+	if !bytes.Equal(data, []byte{0xaa}) {
+		t.Errorf("invalid data %v", data)
+		return
+	}
+
+	// The CodedFramesX has no composition time.
+	h.PacketType = rtmp.RTMP_VIDEO_PACKET_TYPE_CODED_FRAMESX
+	h.CompositionTime = 0
+	if b := h.Dumps(); len(b) != 5 {
+		t.Errorf("invalid dumps %v", b)
+		return
+	}
+
+	// The sequence header of av1.
+	h = &rtmp.RtmpVideoTagHeader{
+		FrameType:  rtmp.RTMP_VIDEO_FRAME_TYPE_KEY_FRAME,
+		IsExHeader: true,
+		PacketType: rtmp.RTMP_VIDEO_PACKET_TYPE_SEQUENCE_START,
+		FourCC:     rtmp.RTMP_FOURCC_AV1,
+	}
+	if nh, _, err := rtmp.ParseRtmpVideoTagHeader(h.Dumps()); err != nil {
+		t.Error("parse av1 sequence header failed. err is", err)
+		return
+	} else if !nh.IsSequenceHeader() || rtmp.RtmpFourCcString(nh.FourCC) != "av01" {
+		t.Errorf("invalid av1 header %+v", nh)
+		return
+	}
+
+	if _, _, err := rtmp.ParseRtmpVideoTagHeader([]byte{0x91, 'h', 'v'}); err == nil {
+		t.Error("should fail for truncated enhanced header")
+		return
+	}
+}
+
+func TestNewAMF0FourCcList(t *testing.T) {
+	it, err := rtmp.NewAMF0FourCcList(rtmp.RtmpEnhancedFourCcList)
+	if err != nil {
+		t.Error("create fourCcList failed. err is", err)
+		return
+	}
+
+	b := it.Dumps()
+	if b[0] != rtmp.STRICT_ARRAY_MARKER {
+		t.Error("marker of fourCcList is invalid")
+		return
+	}
+
+	parsed, err := rtmp.ParseAmf0StrictArray(bytes.NewReader(b[1:]))
+	if err != nil {
+		t.Error("parse fourCcList failed. err is", err)
+		return
+	}
+
+	fourCcs := rtmp.ParseAMF0FourCcList(parsed)
+	if len(fourCcs) != 3 || fourCcs[0] != "av01" || fourCcs[1] != "vp09" || fourCcs[2] != "hvc1" {
+		t.Errorf("invalid fourCcList %v", fourCcs)
+		return
+	}
+
+	if _, err := rtmp.NewAMF0FourCcList([]string{"hevc1"}); err == nil {
+		t.Error("should fail for invalid fourcc")
+		return
+	}
+}
+
+func TestNewRtmpConnectObject(t *testing.T) {
+	obj, err := rtmp.NewRtmpConnectObject("live", "rtmp://ossrs.net/live", rtmp.RtmpEnhancedFourCcList)
+	if err != nil {
+		t.Error("create connect object failed. err is", err)
+		return
+	}
+
+	b := obj.Dumps()
+	if b[0] != rtmp.OBJECT_MARKER {
+		t.Error("marker of connect object is invalid")
+		return
+	}
+
+	parsed, err := rtmp.ParseAMF0Object(bytes.NewReader(b[1:]))
+	if err != nil {
+		t.Error("parse connect object failed. err is", err)
+		return
+	}
+
+	if v := string(parsed.Properties["app"].Bytes); v != "live" {
+		t.Errorf("invalid app %v", v)
+		return
+	}
+
+	fourCcs := rtmp.ParseRtmpConnectFourCcList(parsed)
+	if len(fourCcs) != 3 || fourCcs[0] != "av01" || fourCcs[1] != "vp09" || fourCcs[2] != "hvc1" {
+		t.Errorf("invalid fourCcList %v", fourCcs)
+		return
+	}
+
+	// legacy connect without fourCcList.
+	if obj, err = rtmp.NewRtmpConnectObject("live", "rtmp://ossrs.net/live", nil); err != nil {
+		t.Error("create connect object failed. err is", err)
+		return
+	}
+	if parsed, err = rtmp.ParseAMF0Object(bytes.NewReader(obj.Dumps()[1:])); err != nil {
+		t.Error("parse connect object failed. err is", err)
+		return
+	}
+	if fourCcs := rtmp.ParseRtmpConnectFourCcList(parsed); fourCcs != nil {
+		t.Errorf("invalid fourCcList %v", fourCcs)
+		return
+	}
+}
+
+func TestNewRtmpMsgConnect(t *testing.T) {
+	msg, err := rtmp.NewRtmpMsgConnect("live", "rtmp://ossrs.net/live", rtmp.RtmpEnhancedFourCcList)
+	if err != nil {
+		t.Error("create connect failed. err is", err)
+		return
+	}
+
+	if msg.MessageType != rtmp.RTMP_COMMADNS_MSG_COMMAND_AMF0 || msg.PayloadLength != uint32(len(msg.PayLoad)) {
+		t.Errorf("invalid connect type=%v, length=%v", msg.MessageType, msg.PayloadLength)
+		return
+	}
+
+	// The name, transaction id and command object.
+	r := bytes.NewReader(msg.PayLoad)
+	if marker, _ := r.ReadByte(); marker != rtmp.STRING_MARKER {
+		t.Errorf("invalid marker %v", marker)
+		return
+	} else if name, err := rtmp.ParseAMF0String(r); err != nil || string(name.Bytes) != "connect" {
+		t.Errorf("invalid name, err is %v", err)
+		return
+	}
+
+	if marker, _ := r.ReadByte(); marker != rtmp.NUMBER_MARKER {
+		t.Errorf("invalid marker %v", marker)
+		return
+	} else if tid, err := rtmp.ParseAMF0Number(r); err != nil || tid.Number != 1 {
+		t.Errorf("invalid transaction id, err is %v", err)
+		return
+	}
+
+	if marker, _ := r.ReadByte(); marker != rtmp.OBJECT_MARKER {
+		t.Errorf("invalid marker %v", marker)
+		return
+	} else if obj, err := rtmp.ParseAMF0Object(r); err != nil {
+		t.Error("parse connect object failed. err is", err)
+		return
+	} else if fourCcs := rtmp.ParseRtmpConnectFourCcList(obj); len(fourCcs) != 3 {
+		t.Errorf("invalid fourCcList %v", fourCcs)
+		return
+	}
+}